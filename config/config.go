@@ -4,35 +4,224 @@ import (
 	"github.com/prometheus/log"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"net/url"
+	"reflect"
+	"strconv"
 	"time"
 )
 
 type Config struct {
-	// NewRelic related settings
+	// NewRelic related settings of the single account, and defaults of Accounts
+	Account `yaml:",inline"`
+
+	// NewRelic accounts to scrape, each in its own api.* namespace
+	Accounts []Account `yaml:"accounts"`
+
+	// Metric mapping settings
+	MetricUnitSuffixes bool          `yaml:"metrics.unit-suffixes"`
+	MetricSummaries    bool          `yaml:"metrics.response-time-summaries"`
+	MetricCounters     []string      `yaml:"metrics.counters"`
+	MetricTimestamps   bool          `yaml:"metrics.timestamps"`
+	MetricRelabelRules []RelabelRule `yaml:"metrics.relabel-rules"`
+	MetricAppLabel     string        `yaml:"metrics.app-label"`
+	MetricStaleCycles  int           `yaml:"metrics.stale-cycles"`
+	MetricStaleTTL     time.Duration `yaml:"metrics.stale-ttl"`
+
+	// Probe settings
+	ProbeModules map[string]ProbeModule `yaml:"probe.modules"`
+
+	// Prometheus Exporter related settings
+	MetricPath    string `yaml:"web.telemetry-path"`
+	ListenAddress string `yaml:"web.listen-address"`
+	WebConfigFile string `yaml:"web.config-file"`
+
+	// Debugging settings
+	DebugProxyAddress string `yaml:"debug.proxy-address"`
+
+	// Unknown keys and type errors of the config file
+	decodeErrors []string
+}
+
+// Account holds the settings of one NewRelic account.
+type Account struct {
+	Name                   string        `yaml:"api.account-name"`
 	NRApiKey               string        `yaml:"api.key"`
+	NRApiKeyFile           string        `yaml:"api.key-file"`
+	NRRegion               string        `yaml:"api.region"`
 	NRApiServer            string        `yaml:"api.server"`
+	NRBackend              string        `yaml:"api.backend"`
+	NRAccountID            int           `yaml:"api.account-id"`
+	NRInsightsServer       string        `yaml:"api.insights-server"`
+	NRQueryKey             string        `yaml:"api.query-key"`
 	NRPeriod               int           `yaml:"api.period"`
+	NRDataLag              time.Duration `yaml:"api.data-lag"`
 	NRTimeout              time.Duration `yaml:"api.timeout"`
+	NRProxyURL             string        `yaml:"api.proxy-url"`
+	NRTLSCAFile            string        `yaml:"api.tls-ca-file"`
+	NRTLSCertFile          string        `yaml:"api.tls-cert-file"`
+	NRTLSKeyFile           string        `yaml:"api.tls-key-file"`
+	NRTLSInsecure          bool          `yaml:"api.tls-insecure-skip-verify"`
+	NRMaxRetries           int           `yaml:"api.max-retries"`
+	NRRetryBackoff         time.Duration `yaml:"api.retry-backoff"`
+	NRRateLimit            float64       `yaml:"api.rate-limit"`
+	NRRateBurst            int           `yaml:"api.rate-burst"`
+	NRMaxInFlight          int           `yaml:"api.max-in-flight"`
+	NRHourlyCallBudget     int           `yaml:"api.hourly-call-budget"`
 	NRAppListCacheTime     time.Duration `yaml:"api.apps-list-cache-time"`
 	NRMetricNamesCacheTime time.Duration `yaml:"api.metric-names-cache-time"`
 	NRService              string        `yaml:"api.service"`
 	NRApps                 []Application `yaml:"api.include-apps"`
+	NRExcludeApps          []Application `yaml:"api.exclude-apps"`
 	NRMetricFilters        []string      `yaml:"api.include-metric-filters"`
 	NRValueFilters         []string      `yaml:"api.include-values"`
+	NRHostBreakdown        bool          `yaml:"api.host-breakdown"`
+	NRDeployments          bool          `yaml:"api.deployments"`
+	NRQLQueries            []NRQLQuery   `yaml:"nrql.queries"`
 
-	// Prometheus Exporter related settings
-	MetricPath    string `yaml:"web.telemetry-path"`
-	ListenAddress string `yaml:"web.listen-address"`
+	// Keys given in the config file, so that the settings of an entry of the
+	// accounts list override the top level even with a zero value
+	set map[string]bool
+}
 
-	// Debugging settings
-	DebugProxyAddress string `yaml:"debug.proxy-address"`
+// ProbeModule selects the metrics returned by /probe.
+type ProbeModule struct {
+	MetricFilters []string `yaml:"metric-filters"`
+	Values        []string `yaml:"values"`
 }
 
+// Application selects applications by ID, exact name, glob or regular
+// expression. Only one of the fields is expected to be set.
 type Application struct {
-	Id   int    `yaml:"id"`
-	Name string `yaml:"name"`
+	Id    int    `yaml:"id"`
+	Name  string `yaml:"name"`
+	Glob  string `yaml:"glob"`
+	Regex string `yaml:"regex"`
+}
+
+// NRQLQuery is an NRQL query run every cycle. Each entry of Columns maps a
+// result column (e.g. "average.duration") to a metric name, and Facets names
+// the label for each FACET attribute, in order.
+type NRQLQuery struct {
+	Query   string            `yaml:"query"`
+	Help    string            `yaml:"help"`
+	Columns map[string]string `yaml:"columns"`
+	Facets  []string          `yaml:"facets"`
+}
+
+// RelabelRule turns segments of a New Relic metric path into labels. Match is
+// a regular expression on the path whose named groups become labels. Prefix
+// is prepended to the metric name and Component, which may reference groups
+// as in regexp.Expand, replaces the component label.
+type RelabelRule struct {
+	Match     string `yaml:"match"`
+	Prefix    string `yaml:"prefix"`
+	Component string `yaml:"component"`
+}
+
+// AccountConfigs returns one Config per account to scrape. Without an
+// accounts list that is the config itself. Otherwise the settings an account
+// leaves out are taken from the top level, and accounts without a name are
+// named after their account ID or position. Settings given in the YAML of an
+// account apply even when false or zero, as do non-zero settings of accounts
+// built in code. API locations that are not set explicitly are those of the
+// account's region.
+func (c Config) AccountConfigs() []Config {
+	if len(c.Accounts) == 0 {
+		c.resolveEndpoints()
+		return []Config{c}
+	}
+
+	configs := make([]Config, len(c.Accounts))
+
+	for i, account := range c.Accounts {
+		merged := c.Account
+
+		src := reflect.ValueOf(account)
+		dst := reflect.ValueOf(&merged).Elem()
+		for f := 0; f < src.NumField(); f++ {
+			field := src.Type().Field(f)
+			if field.PkgPath != "" {
+				continue
+			}
+
+			if account.set[field.Tag.Get("yaml")] || !src.Field(f).IsZero() {
+				dst.Field(f).Set(src.Field(f))
+			}
+		}
+
+		if account.Name == "" {
+			if account.NRAccountID != 0 {
+				merged.Name = strconv.Itoa(account.NRAccountID)
+			} else {
+				merged.Name = strconv.Itoa(i)
+			}
+		}
+
+		merged.resolveEndpoints()
+
+		configs[i] = c
+		configs[i].Account = merged
+		configs[i].Accounts = nil
+	}
+
+	return configs
+}
+
+// recordAccountKeys notes the keys given for each entry of the accounts list.
+func (c *Config) recordAccountKeys(source []byte) error {
+	var entries struct {
+		Accounts []yaml.MapSlice `yaml:"accounts"`
+	}
+	if err := yaml.Unmarshal(source, &entries); err != nil {
+		return err
+	}
+
+	for i, entry := range entries.Accounts {
+		c.Accounts[i].set = make(map[string]bool, len(entry))
+		for _, item := range entry {
+			if key, ok := item.Key.(string); ok {
+				c.Accounts[i].set[key] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// redacted returns a copy of the config for logging, without the API keys
+// and the credentials of proxy URLs.
+func (c Config) redacted() Config {
+	c.Account = c.Account.redacted()
+	c.Accounts = append([]Account(nil), c.Accounts...)
+	for i := range c.Accounts {
+		c.Accounts[i] = c.Accounts[i].redacted()
+	}
+	return c
+}
+
+func (a Account) redacted() Account {
+	for _, secret := range []*string{&a.NRApiKey, &a.NRQueryKey} {
+		if *secret != "" {
+			*secret = "redacted"
+		}
+	}
+
+	if a.NRProxyURL != "" {
+		u, err := url.Parse(a.NRProxyURL)
+		if err != nil {
+			a.NRProxyURL = "<invalid>"
+		} else if u.User != nil {
+			u.User = url.User("redacted")
+			a.NRProxyURL = u.String()
+		}
+	}
+
+	return a
 }
 
+// GetConfig reads the config file, with ${VAR} references replaced from the
+// environment, and applies the environment and command line overrides.
+// The result still has to be validated, which also reports unknown keys.
 func GetConfig(path string) (Config, error) {
 	config := Config{}
 	configSource, err := ioutil.ReadFile(path)
@@ -40,12 +229,39 @@ func GetConfig(path string) (Config, error) {
 		return config, err
 	}
 
-	err = yaml.Unmarshal(configSource, &config)
+	// Unknown keys and values of the wrong type are left to Validate, to be
+	// reported along with the other problems
+	source := interpolate(configSource)
+	err = yaml.UnmarshalStrict(source, &config)
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		config.decodeErrors = typeErr.Errors
+	} else if err != nil {
+		return config, err
+	}
+
+	err = config.recordAccountKeys(source)
+	if err != nil {
+		return config, err
+	}
+
+	err = applyOverrides(&config)
+	if err != nil {
+		return config, err
+	}
+
+	err = config.readKeyFile()
 	if err != nil {
 		return config, err
 	}
 
-	log.Debugf("Config loaded: %v", config)
+	for i := range config.Accounts {
+		err = config.Accounts[i].readKeyFile()
+		if err != nil {
+			return config, err
+		}
+	}
+
+	log.Debugf("Config loaded: %v", config.redacted())
 
 	return config, err
 }
@@ -0,0 +1,48 @@
+package config
+
+// Region of the API endpoints when api.region is unset
+const DefaultRegion = "us"
+
+// Endpoints are the API locations of a New Relic datacenter region. The
+// REST and NerdGraph APIs share the API location.
+type Endpoints struct {
+	API      string
+	Insights string
+}
+
+// Regions maps the values of api.region to their endpoints.
+var Regions = map[string]Endpoints{
+	"us": {
+		API:      "https://api.newrelic.com",
+		Insights: "https://insights-api.newrelic.com",
+	},
+	"eu": {
+		API:      "https://api.eu.newrelic.com",
+		Insights: "https://insights-api.eu.newrelic.com",
+	},
+	"fedramp": {
+		API:      "https://gov-api.newrelic.com",
+		Insights: "https://gov-insights-api.newrelic.com",
+	},
+}
+
+// resolveEndpoints sets the API locations that are not given explicitly to
+// those of the account's region.
+func (a *Account) resolveEndpoints() {
+	region := a.NRRegion
+	if region == "" {
+		region = DefaultRegion
+	}
+
+	endpoints, ok := Regions[region]
+	if !ok {
+		return
+	}
+
+	if a.NRApiServer == "" {
+		a.NRApiServer = endpoints.API
+	}
+	if a.NRInsightsServer == "" {
+		a.NRInsightsServer = endpoints.Insights
+	}
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix starts the environment variable of every config key, e.g.
+// NEWRELIC_EXPORTER_API_KEY for api.key.
+const EnvPrefix = "NEWRELIC_EXPORTER_"
+
+// Only upper case names are interpolated, so that relabel rule components can
+// still reference named groups as ${name}.
+var envReference = regexp.MustCompile(`\$\{([A-Z_][A-Z0-9_]*)\}`)
+
+// Flag set holding the command line overrides, see RegisterFlags
+var flags *flag.FlagSet
+
+// interpolate replaces ${VAR} references with the value of the environment
+// variable, or nothing if it is unset.
+func interpolate(source []byte) []byte {
+	return envReference.ReplaceAllFunc(source, func(ref []byte) []byte {
+		return []byte(os.Getenv(string(ref[2 : len(ref)-1])))
+	})
+}
+
+// EnvName returns the environment variable overriding the config key.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// RegisterFlags defines a flag for every config key that can be overridden,
+// e.g. --api.key. Flags set on the command line take precedence over the
+// environment and the config file.
+func RegisterFlags(fs *flag.FlagSet) {
+	for key, field := range settings(&Config{}) {
+		usage := fmt.Sprintf("Overrides %s of the config file (%s)", key, field.Type())
+		if field.Kind() == reflect.Bool {
+			fs.Bool(key, false, usage)
+		} else {
+			fs.String(key, "", usage)
+		}
+	}
+	flags = fs
+}
+
+// applyOverrides sets the keys given in the environment, then those given on
+// the command line.
+func applyOverrides(c *Config) error {
+	fields := settings(c)
+
+	for key, field := range fields {
+		if value, ok := os.LookupEnv(EnvName(key)); ok {
+			if err := setField(field, value); err != nil {
+				return fmt.Errorf("%s: %v", EnvName(key), err)
+			}
+		}
+	}
+
+	if flags == nil || !flags.Parsed() {
+		return nil
+	}
+
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		if field, ok := fields[f.Name]; ok && err == nil {
+			if e := setField(field, f.Value.String()); e != nil {
+				err = fmt.Errorf("--%s: %v", f.Name, e)
+			}
+		}
+	})
+
+	return err
+}
+
+// readKeyFile replaces the API key with the content of api.key-file, as
+// rendered from Kubernetes Secrets or by Vault agent.
+func (a *Account) readKeyFile() error {
+	if a.NRApiKeyFile == "" {
+		return nil
+	}
+
+	key, err := ioutil.ReadFile(a.NRApiKeyFile)
+	if err != nil {
+		return err
+	}
+
+	a.NRApiKey = strings.TrimSpace(string(key))
+	return nil
+}
+
+// settings maps the keys of the top level settings that can be given as a
+// single string to their fields.
+func settings(c *Config) map[string]reflect.Value {
+	fields := make(map[string]reflect.Value)
+	collectSettings(reflect.ValueOf(c).Elem(), fields)
+	return fields
+}
+
+func collectSettings(v reflect.Value, fields map[string]reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		tag := v.Type().Field(i).Tag.Get("yaml")
+		field := v.Field(i)
+
+		if tag == ",inline" {
+			collectSettings(field, fields)
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String, reflect.Int, reflect.Int64, reflect.Float64, reflect.Bool:
+			fields[tag] = field
+		case reflect.Slice:
+			if field.Type().Elem().Kind() == reflect.String {
+				fields[tag] = field
+			}
+		}
+	}
+}
+
+// setField parses value into the field. Lists are comma separated.
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+
+	case reflect.String:
+		field.SetString(value)
+
+	case reflect.Int, reflect.Int64:
+		if field.Type() == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			field.SetInt(int64(d))
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetConfigSources(t *testing.T) {
+
+	dir := t.TempDir()
+
+	keyFile := filepath.Join(dir, "key")
+	err := ioutil.WriteFile(keyFile, []byte("secret-key\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configFile := filepath.Join(dir, "newrelic_exporter.yml")
+	err = ioutil.WriteFile(configFile, []byte(`
+api.key-file: ${TEST_NR_SECRETS}/key
+api.service: applications
+api.timeout: 15s
+api.period: 60
+metrics.relabel-rules:
+  - match: "^Datastore/(?P<datastore>[^/]+)$"
+    component: "${datastore}"
+accounts:
+  - api.account-name: eu
+    api.key: ${TEST_NR_EU_KEY}
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_NR_SECRETS", dir)
+	t.Setenv("TEST_NR_EU_KEY", "eu-key")
+	t.Setenv("NEWRELIC_EXPORTER_API_TIMEOUT", "30s")
+	t.Setenv("NEWRELIC_EXPORTER_API_PERIOD", "120")
+	t.Setenv("NEWRELIC_EXPORTER_API_INCLUDE_METRIC_FILTERS", "Datastore, WebTransaction")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(fs)
+	defer func() { flags = nil }()
+
+	err = fs.Parse([]string{"--api.period=300", "--metrics.unit-suffixes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := GetConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.NRApiKey != "secret-key" {
+		t.Fatal("Expected the key from api.key-file, got", cfg.NRApiKey)
+	}
+
+	if cfg.Accounts[0].NRApiKey != "eu-key" {
+		t.Fatal("Expected the interpolated key, got", cfg.Accounts[0].NRApiKey)
+	}
+
+	if cfg.MetricRelabelRules[0].Component != "${datastore}" {
+		t.Fatal("Lower case references should be kept, got", cfg.MetricRelabelRules[0].Component)
+	}
+
+	if cfg.NRTimeout != 30*time.Second {
+		t.Fatal("Expected the timeout from the environment, got", cfg.NRTimeout)
+	}
+
+	if cfg.NRPeriod != 300 {
+		t.Fatal("Expected the period from the flag, got", cfg.NRPeriod)
+	}
+
+	if !cfg.MetricUnitSuffixes {
+		t.Fatal("Expected unit suffixes from the flag")
+	}
+
+	if !reflect.DeepEqual(cfg.NRMetricFilters, []string{"Datastore", "WebTransaction"}) {
+		t.Fatal("Wrong metric filters", cfg.NRMetricFilters)
+	}
+
+	os.Remove(keyFile)
+
+	if _, err = GetConfig(configFile); err == nil {
+		t.Fatal("Expected an error for a missing key file")
+	}
+
+}
@@ -0,0 +1,256 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Defaults filled in by Validate
+const (
+	DefaultListenPort = "9126"
+	DefaultMetricPath = "/metrics"
+	DefaultPeriod     = 60
+	DefaultTimeout    = 5 * time.Second
+)
+
+// ValidationError lists every problem found in a configuration.
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e, "\n  ")
+}
+
+// Validate fills in the defaults of unset settings and checks the
+// configuration of every account. All problems are returned at once as a
+// ValidationError.
+func (c *Config) Validate() error {
+	problems := append(ValidationError(nil), c.decodeErrors...)
+
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.ListenAddress == "" {
+		c.ListenAddress = ":" + DefaultListenPort
+	} else if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+		// A bare host listens on the default port
+		if _, _, err = net.SplitHostPort(c.ListenAddress + ":" + DefaultListenPort); err != nil {
+			addf("web.listen-address %q: %v", c.ListenAddress, err)
+		} else {
+			c.ListenAddress += ":" + DefaultListenPort
+		}
+	}
+
+	if c.MetricPath == "" {
+		c.MetricPath = DefaultMetricPath
+	} else if !strings.HasPrefix(c.MetricPath, "/") {
+		addf("web.telemetry-path %q must start with /", c.MetricPath)
+	}
+
+	if c.NRPeriod == 0 {
+		c.NRPeriod = DefaultPeriod
+	}
+	if c.NRTimeout == 0 {
+		c.NRTimeout = DefaultTimeout
+	}
+
+	if c.DebugProxyAddress != "" {
+		if _, err := url.Parse(c.DebugProxyAddress); err != nil {
+			addf("debug.proxy-address: %v", err)
+		}
+	}
+
+	for i, rule := range c.MetricRelabelRules {
+		if _, err := regexp.Compile(rule.Match); err != nil {
+			addf("metrics.relabel-rules[%d]: %v", i, err)
+		}
+	}
+
+	switch c.MetricAppLabel {
+	case "", "name", "id", "both":
+	default:
+		addf("metrics.app-label %q is not one of name, id or both", c.MetricAppLabel)
+	}
+
+	if c.MetricStaleCycles < 0 || c.MetricStaleTTL < 0 {
+		addf("metrics.stale-cycles and metrics.stale-ttl must not be negative")
+	}
+
+	modules := make([]string, 0, len(c.ProbeModules))
+	for name := range c.ProbeModules {
+		modules = append(modules, name)
+	}
+	sort.Strings(modules)
+
+	for _, name := range modules {
+		if len(c.ProbeModules[name].MetricFilters) == 0 {
+			addf("probe.modules %q: metric-filters is empty", name)
+		}
+	}
+
+	names := make(map[string]bool)
+
+	for _, account := range c.AccountConfigs() {
+		prefix := ""
+		if len(c.Accounts) > 0 {
+			prefix = fmt.Sprintf("account %q: ", account.Name)
+
+			if names[account.Name] {
+				addf("%sapi.account-name is used by more than one account", prefix)
+			}
+			names[account.Name] = true
+		}
+
+		for _, problem := range account.Account.validate() {
+			problems = append(problems, prefix+problem)
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	return nil
+}
+
+// validate checks the settings of one account with the defaults applied.
+func (a Account) validate() []string {
+	var problems []string
+
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if a.NRApiKey == "" {
+		addf("api.key or api.key-file is required")
+	}
+
+	switch a.NRBackend {
+	case "", "rest":
+		if a.NRService == "" {
+			addf("api.service is required by the rest backend")
+		}
+	case "nerdgraph":
+		if a.NRAccountID == 0 {
+			addf("api.account-id is required by the nerdgraph backend")
+		}
+		if a.NRHostBreakdown {
+			addf("api.host-breakdown needs the rest backend")
+		}
+		if a.NRDeployments {
+			addf("api.deployments needs the rest backend")
+		}
+	default:
+		addf("api.backend %q is neither rest nor nerdgraph", a.NRBackend)
+	}
+
+	if _, ok := Regions[a.NRRegion]; a.NRRegion != "" && !ok {
+		addf("api.region %q is not one of us, eu or fedramp", a.NRRegion)
+	}
+
+	servers := []struct{ key, server string }{
+		{"api.server", a.NRApiServer},
+		{"api.insights-server", a.NRInsightsServer},
+	}
+	for _, s := range servers {
+		key, server := s.key, s.server
+		if server == "" {
+			continue
+		}
+		if u, err := url.Parse(server); err != nil {
+			addf("%s: %v", key, err)
+		} else if u.Scheme == "" || u.Host == "" {
+			addf("%s %q is not an absolute URL", key, server)
+		}
+	}
+
+	if len(a.NRMetricFilters) == 0 {
+		addf("api.include-metric-filters is empty, no metric data would be requested")
+	}
+
+	// The proxy URL may hold credentials, so it is not repeated
+	if a.NRProxyURL != "" {
+		if u, err := url.Parse(a.NRProxyURL); err != nil || u.Scheme == "" || u.Host == "" {
+			addf("api.proxy-url is not an absolute URL")
+		}
+	}
+
+	if (a.NRTLSCertFile == "") != (a.NRTLSKeyFile == "") {
+		addf("api.tls-cert-file and api.tls-key-file have to be given together")
+	}
+
+	if a.NRPeriod < 0 {
+		addf("api.period must be positive")
+	}
+	if a.NRDataLag < 0 {
+		addf("api.data-lag must not be negative")
+	}
+	if a.NRTimeout < 0 {
+		addf("api.timeout must be positive")
+	}
+	if a.NRMaxRetries < -1 {
+		addf("api.max-retries must be -1 or more")
+	}
+	if a.NRRetryBackoff < 0 {
+		addf("api.retry-backoff must not be negative")
+	}
+	if a.NRRateLimit < 0 || a.NRRateBurst < 0 || a.NRMaxInFlight < 0 || a.NRHourlyCallBudget < 0 {
+		addf("api.rate-limit, api.rate-burst, api.max-in-flight and api.hourly-call-budget must not be negative")
+	}
+	if a.NRAppListCacheTime < 0 || a.NRMetricNamesCacheTime < 0 {
+		addf("api.apps-list-cache-time and api.metric-names-cache-time must not be negative")
+	}
+
+	for i, app := range a.NRApps {
+		if problem := app.validate(); problem != "" {
+			addf("api.include-apps[%d]: %s", i, problem)
+		}
+	}
+	for i, app := range a.NRExcludeApps {
+		if problem := app.validate(); problem != "" {
+			addf("api.exclude-apps[%d]: %s", i, problem)
+		}
+	}
+
+	for i, query := range a.NRQLQueries {
+		if query.Query == "" {
+			addf("nrql.queries[%d]: query is empty", i)
+		}
+	}
+	if len(a.NRQLQueries) > 0 {
+		if a.NRAccountID == 0 {
+			addf("nrql.queries need api.account-id")
+		}
+		if a.NRBackend != "nerdgraph" && a.NRQueryKey == "" {
+			addf("nrql.queries need api.query-key with the rest backend")
+		}
+	}
+
+	return problems
+}
+
+func (a Application) validate() string {
+	set := 0
+	for _, field := range []bool{a.Id != 0, a.Name != "", a.Glob != "", a.Regex != ""} {
+		if field {
+			set++
+		}
+	}
+
+	if set != 1 {
+		return "exactly one of id, name, glob or regex is required"
+	}
+
+	if a.Regex != "" {
+		if _, err := regexp.Compile(a.Regex); err != nil {
+			return err.Error()
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestValidateDefaults(t *testing.T) {
+
+	cfg := Config{Account: Account{
+		NRApiKey:        "key",
+		NRService:       "applications",
+		NRMetricFilters: []string{"Datastore"},
+	}}
+
+	err := cfg.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ListenAddress != ":9126" || cfg.MetricPath != "/metrics" {
+		t.Fatal("Wrong web defaults", cfg.ListenAddress, cfg.MetricPath)
+	}
+
+	if cfg.NRPeriod != 60 || cfg.NRTimeout != 5*time.Second {
+		t.Fatal("Wrong API defaults", cfg.NRPeriod, cfg.NRTimeout)
+	}
+
+	cfg.ListenAddress = "localhost"
+
+	err = cfg.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ListenAddress != "localhost:9126" {
+		t.Fatal("Expected the default port, got", cfg.ListenAddress)
+	}
+
+}
+
+func TestValidateProblems(t *testing.T) {
+
+	var cfg Config
+
+	err := yaml.Unmarshal([]byte(`
+api.timeout: -1s
+web.telemetry-path: metrics
+metrics.relabel-rules:
+  - match: "("
+accounts:
+  - api.account-name: us
+    api.service: applications
+    api.include-metric-filters: [Datastore]
+    api.include-apps:
+      - id: 1
+        name: Checkout
+  - api.account-name: us
+    api.key: key
+    api.backend: nerdgraph
+    api.host-breakdown: true
+    api.deployments: true
+`), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cfg.Validate()
+
+	expected := ValidationError{
+		"web.telemetry-path \"metrics\" must start with /",
+		"metrics.relabel-rules[0]: error parsing regexp: missing closing ): `(`",
+		"account \"us\": api.key or api.key-file is required",
+		"account \"us\": api.timeout must be positive",
+		"account \"us\": api.include-apps[0]: exactly one of id, name, glob or regex is required",
+		"account \"us\": api.account-name is used by more than one account",
+		"account \"us\": api.account-id is required by the nerdgraph backend",
+		"account \"us\": api.host-breakdown needs the rest backend",
+		"account \"us\": api.deployments needs the rest backend",
+		"account \"us\": api.include-metric-filters is empty, no metric data would be requested",
+		"account \"us\": api.timeout must be positive",
+	}
+
+	if !reflect.DeepEqual(err, expected) {
+		t.Fatalf("Wrong problems:\n%v", err)
+	}
+
+}
+
+func TestGetConfigUnknownKeys(t *testing.T) {
+
+	configFile := filepath.Join(t.TempDir(), "newrelic_exporter.yml")
+	err := ioutil.WriteFile(configFile, []byte("api.key: key\napi.includ-apps: []\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := GetConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reported along with the other problems
+	err = cfg.Validate()
+	problems, ok := err.(ValidationError)
+	if !ok || len(problems) != 3 || !strings.Contains(problems[0], "field api.includ-apps not found") ||
+		problems[1] != "api.service is required by the rest backend" {
+		t.Fatalf("Wrong problems:\n%v", err)
+	}
+
+}
@@ -1,11 +1,12 @@
 package exporter
 
 import (
-	"fmt"
+	"context"
 	"github.com/mrf/newrelic_exporter/config"
 	"github.com/mrf/newrelic_exporter/newrelic"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/log"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -14,26 +15,53 @@ import (
 const NameSpace = "newrelic"
 
 type Metric struct {
-	App   string
-	Name  string
-	Value float64
-	Label string
+	Name   string
+	Help   string
+	Value  float64
+	Labels prometheus.Labels
+	// Set for summary-shaped metrics instead of Value
+	Summary *Summary
+	// Value, or the count and sum of Summary, are added to the series
+	Counter bool
+	// Time of the sample, if not the time of the Prometheus scrape
+	Timestamp time.Time
+	// Labels whose values identify the series this one replaces: other
+	// series of the metric with the same values of them are dropped
+	ReplaceBy []string
 }
 
+// Longest data window of an application whose counters were not updated by
+// the previous scrapes
+const CounterCatchUp = time.Hour
+
 type Exporter struct {
-	mu                                       sync.Mutex
-	duration, error                          prometheus.Gauge
-	totalScrapes                             prometheus.Counter
-	metrics                                  map[string]prometheus.GaugeVec
-	api                                      *newrelic.API
-	cfg                                      config.Config
-	apps                                     []newrelic.Application
-	names                                    map[int][]newrelic.MetricName
-	values                                   []string
-	appListLastScrape, metricNamesLastScrape time.Time
-}
-
-func NewExporter(api *newrelic.API, cfg config.Config) *Exporter {
+	mu                                     sync.Mutex
+	duration, error, snapshotAge, interval prometheus.Gauge
+	trackedSeries                          prometheus.Gauge
+	totalScrapes                           prometheus.Counter
+	scrapeErrors                           *prometheus.CounterVec
+	metrics                                *snapshot
+	settingsMu                             sync.Mutex
+	api                                    newrelic.Client
+	cfg                                    config.Config
+	mapper                                 *mapper
+	apps                                   []newrelic.Application
+	cacheMu                                sync.Mutex
+	names                                  map[int][]newrelic.MetricName
+	namesLastScrape                        map[int]time.Time
+	dataLastTo                             map[int]time.Time
+	lastDeployment                         map[int]time.Time
+	values                                 []string
+	appListLastScrape                      time.Time
+	lastSnapshot                           time.Time
+}
+
+func NewExporter(api newrelic.Client, cfg config.Config) *Exporter {
+	m, err := newMapper(cfg)
+	if err != nil {
+		log.Fatal("Could not parse metric mapping: ", err)
+	}
+
 	return &Exporter{
 		duration: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: NameSpace,
@@ -50,115 +78,205 @@ func NewExporter(api *newrelic.API, cfg config.Config) *Exporter {
 			Name:      "exporter_last_scrape_error",
 			Help:      "The last scrape error status.",
 		}),
-		metrics: map[string]prometheus.GaugeVec{},
-		api:     api,
-		cfg:     cfg,
-		apps:    make([]newrelic.Application, 0),
-		names:   make(map[int][]newrelic.MetricName),
-		values:  make([]string, 0),
+		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: NameSpace,
+			Name:      "exporter_scrape_errors_total",
+			Help:      "Failed API requests, by application and scrape stage.",
+		}, []string{"app", "stage"}),
+		snapshotAge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: NameSpace,
+			Name:      "exporter_snapshot_age_seconds",
+			Help:      "Seconds since the served metrics were last scraped from the API.",
+		}),
+		interval: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: NameSpace,
+			Name:      "exporter_poll_interval_seconds",
+			Help:      "Current interval between scrapes of the API.",
+		}),
+		trackedSeries: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: NameSpace,
+			Name:      "exporter_tracked_series",
+			Help:      "Series of scraped metrics currently served.",
+		}),
+		metrics:         newSnapshot(),
+		api:             api,
+		cfg:             cfg,
+		mapper:          m,
+		apps:            make([]newrelic.Application, 0),
+		names:           make(map[int][]newrelic.MetricName),
+		namesLastScrape: make(map[int]time.Time),
+		dataLastTo:      make(map[int]time.Time),
+		lastDeployment:  make(map[int]time.Time),
+		values:          make([]string, 0),
 	}
 }
 
-func (e *Exporter) scrape(from time.Time, to time.Time, ch chan<- Metric) {
+// settings returns the API client, configuration and metric mapping in
+// effect, which PrepareReload replaces.
+func (e *Exporter) settings() (newrelic.Client, config.Config, *mapper) {
+	e.settingsMu.Lock()
+	defer e.settingsMu.Unlock()
+
+	return e.api, e.cfg, e.mapper
+}
+
+func (e *Exporter) scrape(ctx context.Context, from time.Time, to time.Time, ch chan<- Metric) {
 	e.error.Set(0)
 	e.totalScrapes.Inc()
 
+	api, cfg, mapper := e.settings()
+
 	startTime := time.Now()
 	log.Infof("Starting new scrape at %v for period from %v to %v.", startTime, from.Format(time.Stamp), to.Format(time.Stamp))
 
-	if time.Since(e.appListLastScrape) >= e.cfg.NRAppListCacheTime {
-		var err error
-		e.apps, err = e.api.GetApplications()
+	e.cacheMu.Lock()
+	appListLastScrape := e.appListLastScrape
+	e.cacheMu.Unlock()
+
+	if time.Since(appListLastScrape) >= cfg.NRAppListCacheTime {
+		apps, err := api.GetApplications(ctx)
 		if err != nil {
-			log.Error(err)
-			e.error.Set(1)
+			e.fail("", "applications", err)
 		} else {
+			e.cacheMu.Lock()
+			e.apps = apps
 			// Only successful tries should touch cache times
 			e.appListLastScrape = time.Now()
-			log.Debugf("Application list updated at %v", e.appListLastScrape)
+			e.cacheMu.Unlock()
+
+			log.Debug("Application list updated")
 		}
 	} else {
 		log.Debug("Applications list taken from cache")
 	}
 
-	for _, app := range e.apps {
+	e.cacheMu.Lock()
+	apps := e.apps
+	e.cacheMu.Unlock()
+
+	for _, app := range apps {
 		for name, value := range app.AppSummary {
+			name, value := mapper.summary("application_summary", name, value)
 			ch <- Metric{
-				App:   app.Name,
-				Name:  name,
-				Value: value,
-				Label: "application_summary",
+				Name:   name,
+				Value:  value,
+				Labels: mapper.app(prometheus.Labels{"component": "application_summary"}, app),
 			}
 		}
 
 		for name, value := range app.UsrSummary {
+			name, value := mapper.summary("end_user_summary", name, value)
 			ch <- Metric{
-				App:   app.Name,
-				Name:  name,
-				Value: value,
-				Label: "end_user_summary",
+				Name:   name,
+				Value:  value,
+				Labels: mapper.app(prometheus.Labels{"component": "end_user_summary"}, app),
 			}
 		}
+
+		sendHealth(mapper, app, ch)
+		sendInfo(mapper, app, ch)
 	}
 
 	var wg sync.WaitGroup
 
-	for _, app := range e.apps {
+	for _, app := range apps {
 		wg.Add(1)
 
 		go func(app newrelic.Application) {
 			defer wg.Done()
 
 			var err error
-			var names []newrelic.MetricName
 
-			if time.Since(e.metricNamesLastScrape) >= e.cfg.NRAppListCacheTime {
-				names, err = e.api.GetMetricNames(app.ID)
-				e.names[app.ID] = names
+			if cfg.NRDeployments {
+				e.scrapeDeployments(ctx, api, mapper, app, ch)
+			}
+
+			// Counters are only updated from complete data
+			complete := true
+
+			e.cacheMu.Lock()
+			names := e.names[app.ID]
+			lastScrape := e.namesLastScrape[app.ID]
+			lastTo, counted := e.dataLastTo[app.ID]
+			e.cacheMu.Unlock()
+
+			if time.Since(lastScrape) >= cfg.NRMetricNamesCacheTime {
+				names, err = api.GetMetricNames(ctx, app.ID)
 				log.Infof("Scraped %v metric names for app %v", len(names), app.ID)
+
+				e.cacheMu.Lock()
+				e.names[app.ID] = names
 				if err != nil {
-					log.Error(err)
-					e.error.Set(1)
+					e.fail(app.Name, "metric_names", err)
+					complete = false
 				} else {
 					// Only successful tries should touch cache times
-					e.metricNamesLastScrape = time.Now()
-					log.Debugf("Metric names list updated at %v", e.appListLastScrape)
+					e.namesLastScrape[app.ID] = time.Now()
+					log.Debugf("Metric names list updated at %v", e.namesLastScrape[app.ID])
 				}
+				e.cacheMu.Unlock()
 			} else {
 				log.Debug("Metrics names list taken from cache")
 			}
 
+			// Counters cover the periods since their last update, missed or
+			// not yet counted after a change of the window, and are left
+			// out of windows that were counted already
+			appFrom := from
+			recounted := false
+			if (len(cfg.MetricCounters) > 0 || mapper.summaries) && counted {
+				if !to.After(lastTo) {
+					recounted = true
+				} else if to.Sub(lastTo) <= CounterCatchUp {
+					appFrom = lastTo
+				}
+			}
+
 			// Getting metric data
 			var data []newrelic.MetricData
 
-			data, err = e.api.GetMetricData(app.ID, e.names[app.ID], from, to)
+			data, err = api.GetMetricData(ctx, app.ID, names, appFrom, to)
 			log.Infof("Scraped %v metric datas for app %v", len(data), app.ID)
 			if err != nil {
-				log.Error(err)
-				e.error.Set(1)
+				e.fail(app.Name, "metric_data", err)
+				complete = false
 			}
 
-			// Sending metrics
-			for _, set := range data {
-				if len(set.Timeslices) == 0 {
-					continue
-				}
+			// Host data covers the same window, its counters are only
+			// added together with the application's
+			var hosts []hostData
+			if cfg.NRHostBreakdown {
+				var ok bool
+				hosts, ok = e.scrapeHosts(ctx, api, mapper, app, names, appFrom, to, ch)
+				complete = complete && ok
+			}
 
-				// As we set summarise=true there will only be one timeseries.
-				for name, value := range set.Timeslices[0].Values {
-					if v, ok := value.(float64); ok {
-						ch <- Metric{
-							App:   app.Name,
-							Name:  name,
-							Value: v,
-							Label: set.Name,
-						}
-					}
-				}
+			counters := countersSkipped
+			if complete && !recounted {
+				counters = countersAdded
+
+				e.cacheMu.Lock()
+				e.dataLastTo[app.ID] = to
+				e.cacheMu.Unlock()
+			}
+
+			sendMetricData(mapper, app, "", data, counters, ch)
+			for _, host := range hosts {
+				sendMetricData(mapper, app, host.host, host.data, counters, ch)
 			}
 		}(app)
 	}
 
+	for _, query := range cfg.NRQLQueries {
+		wg.Add(1)
+
+		go func(query config.NRQLQuery) {
+			defer wg.Done()
+
+			e.scrapeNRQL(ctx, api, query, ch)
+		}(query)
+	}
+
 	wg.Wait()
 
 	close(ch)
@@ -167,57 +285,517 @@ func (e *Exporter) scrape(from time.Time, to time.Time, ch chan<- Metric) {
 	log.Infof("Scrape finished in %v", time.Since(startTime))
 }
 
-func (e *Exporter) receive(ch <-chan Metric) {
-	for metric := range ch {
-		id := fmt.Sprintf("%s_%s", NameSpace, metric.Name)
+// Health statuses of applications, exported as a state set
+var healthStatuses = []string{"green", "orange", "red", "gray", "unknown"}
 
-		if m, ok := e.metrics[id]; ok {
-			m.WithLabelValues(metric.App, metric.Label).Set(metric.Value)
-		} else {
-			g := prometheus.NewGaugeVec(
-				prometheus.GaugeOpts{
-					Namespace: NameSpace,
-					Name:      metric.Name,
-				},
-				[]string{"app", "component"})
+// sendHealth sends the health status and reporting state of an application.
+func sendHealth(mapper *mapper, app newrelic.Application, ch chan<- Metric) {
+	health := "unknown"
+	for _, status := range healthStatuses {
+		if app.Health == status {
+			health = status
+		}
+	}
 
-			e.metrics[id] = *g
+	for _, status := range healthStatuses {
+		ch <- Metric{
+			Name:   "app_health_status",
+			Help:   "Health status of the application, 1 for the current one.",
+			Value:  boolValue(status == health),
+			Labels: mapper.app(prometheus.Labels{"status": status}, app),
+		}
+	}
+
+	ch <- Metric{
+		Name:   "app_reporting",
+		Help:   "Whether agents of the application are reporting data.",
+		Value:  boolValue(app.Reporting),
+		Labels: mapper.app(prometheus.Labels{}, app),
+	}
+
+	if !app.LastReportedAt.IsZero() {
+		ch <- Metric{
+			Name:   "app_last_reported_timestamp_seconds",
+			Help:   "Time the application last reported data.",
+			Value:  float64(app.LastReportedAt.UnixNano()) / 1e9,
+			Labels: mapper.app(prometheus.Labels{}, app),
 		}
 	}
 }
 
-func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+// sendInfo sends the metadata of an application as an info metric and its
+// settings as app_setting_ gauges.
+func sendInfo(mapper *mapper, app newrelic.Application, ch chan<- Metric) {
+	alertPolicy := ""
+	if app.Links.AlertPolicy != 0 {
+		alertPolicy = strconv.Itoa(app.Links.AlertPolicy)
+	}
+
+	ch <- Metric{
+		Name:  "app_info",
+		Help:  "Metadata of the application, always 1.",
+		Value: 1,
+		Labels: mapper.app(prometheus.Labels{
+			"id":           strconv.Itoa(app.ID),
+			"language":     app.Language,
+			"alert_policy": alertPolicy,
+		}, app),
+	}
+
+	for name, setting := range app.Settings {
+		var value float64
+
+		switch v := setting.(type) {
+		case float64:
+			value = v
+		case bool:
+			value = boolValue(v)
+		default:
+			continue
+		}
+
+		ch <- Metric{
+			Name:   "app_setting_" + name,
+			Help:   "Setting " + name + " of the application.",
+			Value:  value,
+			Labels: mapper.app(prometheus.Labels{}, app),
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// How sendMetricData exports the values listed in metrics.counters
+type counterMode int
+
+const (
+	// As gauges of the period, for one-off scrapes such as probes
+	countersAsGauges counterMode = iota
+	// As counters the values are added to
+	countersAdded
+	// Left out, as the data of the period is incomplete
+	countersSkipped
+)
+
+// scrapeDeployments sends the time of the last deployment of an application
+// and counts the deployments since the previous scrape. The first scrape only
+// requests the latest deployments and counts none.
+func (e *Exporter) scrapeDeployments(ctx context.Context, api newrelic.Client, mapper *mapper, app newrelic.Application, ch chan<- Metric) {
+	e.cacheMu.Lock()
+	last, seen := e.lastDeployment[app.ID]
+	e.cacheMu.Unlock()
+
+	since := last
+	if !seen {
+		since = time.Now()
+	}
+
+	deployments, err := api.GetDeployments(ctx, app.ID, since)
+	log.Infof("Scraped %v deployments for app %v", len(deployments), app.ID)
+	if err != nil {
+		e.fail(app.Name, "deployments", err)
+		return
+	}
+
+	count := 0
+	if seen {
+		for _, deployment := range deployments {
+			if deployment.Timestamp.After(last) {
+				count++
+			}
+		}
+	}
+
+	ch <- Metric{
+		Name:    "app_deployments_total",
+		Help:    "Deployments of the application since the exporter started.",
+		Value:   float64(count),
+		Labels:  mapper.app(prometheus.Labels{}, app),
+		Counter: true,
+	}
+
+	newest := last
+
+	if len(deployments) > 0 {
+		latest := deployments[0]
+
+		ch <- Metric{
+			Name:      "app_last_deployment_timestamp_seconds",
+			Help:      "Time of the last deployment of the application.",
+			Value:     float64(latest.Timestamp.UnixNano()) / 1e9,
+			Labels:    mapper.app(prometheus.Labels{"revision": latest.Revision, "user": latest.User}, app),
+			ReplaceBy: []string{"app_id"},
+		}
+
+		if latest.Timestamp.After(newest) {
+			newest = latest.Timestamp
+		}
+	}
+
+	e.cacheMu.Lock()
+	e.lastDeployment[app.ID] = newest
+	e.cacheMu.Unlock()
+}
+
+// hostData is the metric data of an application on one host.
+type hostData struct {
+	host string
+	data []newrelic.MetricData
+}
+
+// scrapeHosts sends the summaries of the hosts and instances of an
+// application and returns the metric data of its hosts. It reports whether
+// the metric data of all hosts was scraped.
+func (e *Exporter) scrapeHosts(ctx context.Context, api newrelic.Client, mapper *mapper, app newrelic.Application, names []newrelic.MetricName, from time.Time, to time.Time, ch chan<- Metric) ([]hostData, bool) {
+	instances, err := api.GetInstances(ctx, app.ID)
+	log.Infof("Scraped %v instances for app %v", len(instances), app.ID)
+	if err != nil {
+		e.fail(app.Name, "instances", err)
+	}
+
+	for _, instance := range instances {
+		for name, value := range instance.AppSummary {
+			name, value := mapper.summary("application_summary", name, value)
+			ch <- Metric{
+				Name:  "instance_" + name,
+				Value: value,
+				Labels: mapper.app(prometheus.Labels{
+					"host":      instance.Host,
+					"instance":  strconv.Itoa(instance.ID),
+					"component": "application_summary",
+				}, app),
+			}
+		}
+	}
+
+	hosts, err := api.GetHosts(ctx, app.ID)
+	log.Infof("Scraped %v hosts for app %v", len(hosts), app.ID)
+	if err != nil {
+		e.fail(app.Name, "hosts", err)
+		return nil, false
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	complete := true
+	data := make([]hostData, len(hosts))
+
+	for i, host := range hosts {
+		for name, value := range host.AppSummary {
+			name, value := mapper.summary("application_summary", name, value)
+			ch <- Metric{
+				Name:   "host_" + name,
+				Value:  value,
+				Labels: mapper.app(prometheus.Labels{"host": host.Host, "component": "application_summary"}, app),
+			}
+		}
+
+		wg.Add(1)
+
+		go func(i int, host newrelic.Host) {
+			defer wg.Done()
+
+			metricData, err := api.GetHostMetricData(ctx, app.ID, host.ID, names, from, to)
+			log.Infof("Scraped %v metric datas for host %v of app %v", len(metricData), host.ID, app.ID)
+			if err != nil {
+				e.fail(app.Name, "host_metric_data", err)
+
+				mu.Lock()
+				complete = false
+				mu.Unlock()
+			}
+
+			data[i].host = host.Host
+			data[i].data = metricData
+		}(i, host)
+	}
+
+	wg.Wait()
+
+	return data, complete
+}
+
+// sendMetricData sends the timeslice values of an application or, if host is
+// set, of the application on that host as host_ metrics with a host label.
+func sendMetricData(mapper *mapper, app newrelic.Application, host string, data []newrelic.MetricData, counters counterMode, ch chan<- Metric) {
+	prefix := ""
+	if host != "" {
+		prefix = "host_"
+	}
+
+	for _, set := range data {
+		if len(set.Timeslices) == 0 {
+			continue
+		}
+
+		// As we set summarise=true there will only be one timeseries.
+		values := set.Timeslices[0].Values
+
+		var timestamp time.Time
+		if mapper.timestamps {
+			timestamp = set.Timeslices[0].To
+		}
+
+		summarized := false
+		if mapper.summaries {
+			var metrics []Metric
+			if metrics, summarized = mapper.responseTime(set.Name, values); summarized {
+				for _, metric := range metrics {
+					// Count and sum of the summary always accumulate
+					if metric.Summary != nil && counters != countersAsGauges {
+						if counters == countersSkipped {
+							continue
+						}
+						metric.Counter = true
+					}
+
+					metric.Name = prefix + metric.Name
+					mapper.app(metric.Labels, app)
+					if host != "" {
+						metric.Labels["host"] = host
+					}
+					metric.Timestamp = timestamp
+					ch <- metric
+				}
+			}
+		}
+
+		for name, value := range values {
+			if summarized && responseTimeValues[name] {
+				continue
+			}
+
+			counter := counters != countersAsGauges && mapper.counters[name]
+			if counter && counters == countersSkipped {
+				continue
+			}
+
+			if v, ok := value.(float64); ok {
+				name, v, labels := mapper.timeslice(set.Name, name, v)
+				name = prefix + name
+				mapper.app(labels, app)
+				if host != "" {
+					labels["host"] = host
+				}
+
+				if counter {
+					name += "_total"
+				}
+
+				ch <- Metric{
+					Name:      name,
+					Value:     v,
+					Labels:    labels,
+					Counter:   counter,
+					Timestamp: timestamp,
+				}
+			}
+		}
+	}
+}
+
+// fail records the failed requests of a scrape stage. Partial failures count
+// every failed request.
+func (e *Exporter) fail(app, stage string, err error) {
+	log.Error(err)
+	e.error.Set(1)
+
+	n := 1
+	if errs, ok := err.(newrelic.Errors); ok {
+		n = len(errs)
+	}
+
+	e.scrapeErrors.WithLabelValues(app, stage).Add(float64(n))
+}
+
+// scrapeNRQL runs a configured NRQL query and sends one metric per mapped
+// column and facet.
+func (e *Exporter) scrapeNRQL(ctx context.Context, api newrelic.Client, query config.NRQLQuery, ch chan<- Metric) {
+	results, err := api.QueryNRQL(ctx, query.Query)
+	log.Infof("Scraped %v NRQL results for %q", len(results), query.Query)
+	if err != nil {
+		e.fail("", "nrql", err)
+		return
+	}
+
+	help := query.Help
+	if help == "" {
+		help = "NRQL: " + query.Query
+	}
+
+	for _, result := range results {
+		labels := prometheus.Labels{}
+		for i, name := range query.Facets {
+			if i < len(result.Facets) {
+				labels[name] = result.Facets[i]
+			} else {
+				labels[name] = ""
+			}
+		}
+
+		for column, name := range query.Columns {
+			if v, ok := result.Values[column]; ok {
+				ch <- Metric{
+					Name:   name,
+					Help:   help,
+					Value:  v,
+					Labels: labels,
+				}
+			}
+		}
+	}
+}
+
+// Run scrapes the API in the background once per api.period, aligned to
+// period boundaries, until ctx is cancelled. Collect only serves the snapshot
+// of the last completed scrape, so Prometheus scrapes never hit the API.
+// The interval is stretched when the API call budget would not last.
+func (e *Exporter) Run(ctx context.Context) {
+	for {
+		api, cfg, _ := e.settings()
+		period := scrapePeriod(cfg)
+
+		before := api.Budget().Calls
+
+		e.poll(ctx, period)
+
+		budget := api.Budget()
+		interval := stretch(period, budget, budget.Calls-before)
+		if interval > period {
+			log.Warnf("Stretching poll interval to %v, %v API calls left until %v", interval, budget.Remaining, budget.Reset.Format(time.Stamp))
+		}
+		e.interval.Set(interval.Seconds())
+
+		next := time.Now().Truncate(period).Add(interval)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// poll scrapes the last full period before api.data-lag and swaps the
+// results into the snapshot, dropping series gone stale. The scrape has to
+// finish by pollDeadline.
+func (e *Exporter) poll(ctx context.Context, period time.Duration) {
+	_, cfg, _ := e.settings()
+
+	now := time.Now()
+	from, to := window(now, period, cfg.NRDataLag)
+
+	ctx, cancel := context.WithDeadline(ctx, pollDeadline(now, period))
+	defer cancel()
+
+	metricChan := make(chan Metric)
+
+	go e.scrape(ctx, from, to, metricChan)
+
+	var metrics []Metric
+	for metric := range metricChan {
+		metrics = append(metrics, metric)
+	}
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	for _, m := range e.metrics {
-		m.Describe(ch)
+	e.metrics.receive(metrics)
+	if n := e.metrics.evict(cfg.MetricStaleCycles, cfg.MetricStaleTTL); n > 0 {
+		log.Infof("Dropped %d stale series", n)
 	}
+	e.lastSnapshot = time.Now()
+}
+
+// window returns the last full period that ended lag before now.
+func window(now time.Time, period, lag time.Duration) (time.Time, time.Time) {
+	to := now.Add(-lag).Truncate(period)
+	return to.Add(-period), to
+}
+
+// pollDeadline returns the end of the period a poll started at now. Polls
+// that start late in a period, like the first one, get the next period as
+// well, so that they have at least a full period.
+func pollDeadline(now time.Time, period time.Duration) time.Time {
+	deadline := now.Truncate(period).Add(period)
+	if deadline.Sub(now) < period-period/10 {
+		deadline = deadline.Add(period)
+	}
+	return deadline
+}
+
+// scrapePeriod returns the length of the scraped time window, api.period.
+func scrapePeriod(cfg config.Config) time.Duration {
+	if cfg.NRPeriod <= 0 {
+		return time.Minute
+	}
+	return time.Duration(cfg.NRPeriod) * time.Second
+}
+
+// stretch returns the interval between polls that makes the remaining call
+// budget last until it resets, given the calls one poll makes. The result is
+// a whole number of periods.
+func stretch(period time.Duration, budget newrelic.Budget, calls int64) time.Duration {
+	if budget.Remaining < 0 || calls <= 0 {
+		return period
+	}
+
+	interval := time.Until(budget.Reset)
+	if polls := int64(budget.Remaining) / calls; polls > 0 {
+		interval /= time.Duration(polls)
+	}
+
+	if interval <= period {
+		return period
+	}
+
+	return (interval + period - 1) / period * period
+}
+
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	api, _, _ := e.settings()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.metrics.Describe(ch)
 
 	ch <- e.duration.Desc()
 	ch <- e.totalScrapes.Desc()
 	ch <- e.error.Desc()
+	e.scrapeErrors.Describe(ch)
+	ch <- e.snapshotAge.Desc()
+	ch <- e.interval.Desc()
+	ch <- e.trackedSeries.Desc()
+
+	api.Describe(ch)
 }
 
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	api, _, _ := e.settings()
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	var from, to time.Time
-	from = time.Now().Add(-1 * time.Minute).Truncate(time.Minute)
-	to = from.Add(time.Minute)
-
-	metricChan := make(chan Metric)
-
-	go e.scrape(from, to, metricChan)
-
-	e.receive(metricChan)
+	if !e.lastSnapshot.IsZero() {
+		e.snapshotAge.Set(time.Since(e.lastSnapshot).Seconds())
+	}
+	e.trackedSeries.Set(float64(e.metrics.len()))
 
 	ch <- e.duration
 	ch <- e.totalScrapes
 	ch <- e.error
+	e.scrapeErrors.Collect(ch)
+	ch <- e.snapshotAge
+	ch <- e.interval
+	ch <- e.trackedSeries
 
-	for _, m := range e.metrics {
-		m.Collect(ch)
-	}
+	api.Collect(ch)
+
+	e.metrics.Collect(ch)
 }
@@ -0,0 +1,424 @@
+package exporter
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrf/newrelic_exporter/config"
+	"github.com/mrf/newrelic_exporter/newrelic"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var testApiKey string = "205071e37e95bdaa327c62ccd3201da9289ccd17"
+var testTimeout time.Duration = 5 * time.Second
+
+func testExporter(url string) *Exporter {
+	cfg := config.Config{Account: config.Account{
+		NRApiKey:        testApiKey,
+		NRApiServer:     url,
+		NRService:       "applications",
+		NRPeriod:        60,
+		NRTimeout:       testTimeout,
+		NRMetricFilters: []string{"Datastore/statement/JDBC/messages"},
+	}}
+
+	return NewExporter(newrelic.NewAPI(cfg), cfg)
+}
+
+func TestScrapeAPI(t *testing.T) {
+
+	ts := testServer()
+	defer ts.Close()
+
+	exporter := testExporter(ts.URL)
+
+	var recieved []Metric
+
+	metrics := make(chan Metric)
+
+	go exporter.scrape(context.Background(), time.Time{}, time.Time{}, metrics)
+
+	for m := range metrics {
+		recieved = append(recieved, m)
+	}
+
+	if len(recieved) != 33 {
+		t.Fatal("Expected 33 metrics, got", len(recieved))
+	}
+
+}
+
+func TestCollectServesSnapshot(t *testing.T) {
+
+	ts := testServer()
+	defer ts.Close()
+
+	exporter := testExporter(ts.URL)
+
+	exporter.poll(context.Background(), time.Minute)
+
+	// Collect must not reach the API once a snapshot exists.
+	ts.Close()
+
+	value := snapshotValue(exporter.metrics, "newrelic_call_count", "Test/Client/Name", "9045822", "Datastore/statement/JDBC/messages/insert")
+	if value != 2 {
+		t.Fatal("Wrong call_count value", value)
+	}
+
+	// 33 gauges, six exporter metrics and the API call and throttling counters
+	if n := testutil.CollectAndCount(exporter); n != 41 {
+		t.Fatal("Expected 41 collected metrics, got", n)
+	}
+
+	if testutil.ToFloat64(exporter.trackedSeries) != 33 {
+		t.Fatal("Expected 33 tracked series, got", testutil.ToFloat64(exporter.trackedSeries))
+	}
+
+	if testutil.ToFloat64(exporter.totalScrapes) != 1 {
+		t.Fatal("Collect should not trigger a scrape")
+	}
+}
+
+func TestStretch(t *testing.T) {
+
+	budget := newrelic.Budget{
+		Remaining: 100,
+		Reset:     time.Now().Add(30 * time.Minute),
+	}
+
+	// 10 polls left for half an hour
+	if interval := stretch(time.Minute, budget, 10); interval != 3*time.Minute {
+		t.Fatal("Expected a 3m interval, got", interval)
+	}
+
+	if interval := stretch(time.Minute, budget, 1); interval != time.Minute {
+		t.Fatal("Budget lasts, expected the period, got", interval)
+	}
+
+	budget.Remaining = 5
+	if interval := stretch(time.Minute, budget, 10); interval != 30*time.Minute {
+		t.Fatal("Expected to wait for the budget reset, got", interval)
+	}
+
+	budget.Remaining = -1
+	if interval := stretch(time.Minute, budget, 10); interval != time.Minute {
+		t.Fatal("Expected the period without a budget, got", interval)
+	}
+
+}
+
+func TestPollDeadline(t *testing.T) {
+
+	boundary := time.Date(2015, 6, 8, 15, 37, 0, 0, time.UTC)
+
+	if deadline := pollDeadline(boundary.Add(time.Second), time.Minute); !deadline.Equal(boundary.Add(time.Minute)) {
+		t.Fatal("Expected a poll on the boundary to end with the period, got", deadline)
+	}
+
+	if deadline := pollDeadline(boundary.Add(58*time.Second), time.Minute); !deadline.Equal(boundary.Add(2 * time.Minute)) {
+		t.Fatal("Expected a late poll to get the next period, got", deadline)
+	}
+
+}
+
+func TestTimestamps(t *testing.T) {
+
+	now := time.Date(2015, 6, 8, 15, 37, 30, 0, time.UTC)
+	from, to := window(now, time.Minute, 90*time.Second)
+	if !to.Equal(time.Date(2015, 6, 8, 15, 36, 0, 0, time.UTC)) || to.Sub(from) != time.Minute {
+		t.Fatal("Wrong window for a 90s lag", from, to)
+	}
+
+	ts := testServer()
+	defer ts.Close()
+
+	exporter := testExporter(ts.URL)
+	exporter.mapper.timestamps = true
+
+	exporter.poll(context.Background(), time.Minute)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		exporter.metrics.Collect(ch)
+		close(ch)
+	}()
+
+	expected := time.Date(2015, 6, 8, 15, 36, 0, 0, time.UTC).UnixMilli()
+
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+
+		var component string
+		for _, label := range metric.Label {
+			if label.GetName() == "component" {
+				component = label.GetValue()
+			}
+		}
+
+		// Application summaries and states are not timeslices and keep the
+		// scrape time
+		if component == "" || strings.HasSuffix(component, "_summary") {
+			if metric.TimestampMs != nil {
+				t.Fatal("Unexpected timestamp on", m.Desc())
+			}
+		} else if metric.GetTimestampMs() != expected {
+			t.Fatal("Expected the timeslice end as timestamp, got", metric.GetTimestampMs())
+		}
+	}
+
+}
+
+func TestHealth(t *testing.T) {
+
+	ts := testServer()
+	defer ts.Close()
+
+	exporter := testExporter(ts.URL)
+
+	exporter.poll(context.Background(), time.Minute)
+
+	if snapshotValue(exporter.metrics, "newrelic_app_health_status", "Test/Client/Name", "9045822", "green") != 1 ||
+		snapshotValue(exporter.metrics, "newrelic_app_health_status", "Test/Client/Name", "9045822", "red") != 0 {
+		t.Fatal("Expected the green health status to be set")
+	}
+
+	if snapshotValue(exporter.metrics, "newrelic_app_reporting", "Test/Client/Name", "9045822") != 1 {
+		t.Fatal("Expected the application to be reporting")
+	}
+
+	value := snapshotValue(exporter.metrics, "newrelic_app_last_reported_timestamp_seconds", "Test/Client/Name", "9045822")
+	if value != float64(time.Date(2015, 6, 8, 14, 44, 26, 0, time.UTC).Unix()) {
+		t.Fatal("Wrong last reported time", value)
+	}
+
+}
+
+func TestInfo(t *testing.T) {
+
+	ts := testServer()
+	defer ts.Close()
+
+	exporter := testExporter(ts.URL)
+
+	exporter.poll(context.Background(), time.Minute)
+
+	// Label values in label name order: alert_policy, app, app_id, id, language
+	if snapshotValue(exporter.metrics, "newrelic_app_info", "362921", "Test/Client/Name", "9045822", "9045822", "ruby") != 1 {
+		t.Fatal("Expected the info metric of the application")
+	}
+
+	if value := snapshotValue(exporter.metrics, "newrelic_app_setting_app_apdex_threshold", "Test/Client/Name", "9045822"); value != 0.5 {
+		t.Fatal("Wrong apdex threshold", value)
+	}
+
+	if value := snapshotValue(exporter.metrics, "newrelic_app_setting_enable_real_user_monitoring", "Test/Client/Name", "9045822"); value != 1 {
+		t.Fatal("Expected real user monitoring to be enabled, got", value)
+	}
+
+}
+
+func TestDeployments(t *testing.T) {
+
+	ts := testServer()
+	defer ts.Close()
+
+	exporter := testExporter(ts.URL)
+	exporter.cfg.NRDeployments = true
+
+	exporter.poll(context.Background(), time.Minute)
+
+	value := snapshotValue(exporter.metrics, "newrelic_app_last_deployment_timestamp_seconds", "Test/Client/Name", "9045822", "a1b2c3d", "deploy-bot")
+	if value != float64(time.Date(2015, 6, 8, 14, 30, 0, 0, time.UTC).Unix()) {
+		t.Fatal("Wrong last deployment time", value)
+	}
+
+	if value := snapshotValue(exporter.metrics, "newrelic_app_deployments_total", "Test/Client/Name", "9045822"); value != 0 {
+		t.Fatal("Deployments before the first scrape should not be counted, got", value)
+	}
+
+	// Pretend the previous scrape saw the deployment of June 1st only
+	exporter.cacheMu.Lock()
+	exporter.lastDeployment[9045822] = time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	exporter.cacheMu.Unlock()
+
+	exporter.poll(context.Background(), time.Minute)
+	exporter.poll(context.Background(), time.Minute)
+
+	if value := snapshotValue(exporter.metrics, "newrelic_app_deployments_total", "Test/Client/Name", "9045822"); value != 2 {
+		t.Fatal("Expected the two newer deployments to be counted once, got", value)
+	}
+
+}
+
+func TestHostBreakdown(t *testing.T) {
+
+	ts := testServer()
+	defer ts.Close()
+
+	exporter := testExporter(ts.URL)
+	exporter.cfg.NRHostBreakdown = true
+
+	exporter.poll(context.Background(), time.Minute)
+
+	value := snapshotValue(exporter.metrics, "newrelic_host_call_count", "Test/Client/Name", "9045822", "Datastore/statement/JDBC/messages/insert", "web-1")
+	if value != 2 {
+		t.Fatal("Wrong host call_count value", value)
+	}
+
+	value = snapshotValue(exporter.metrics, "newrelic_host_throughput", "Test/Client/Name", "9045822", "application_summary", "web-1")
+	if value != 27.3 {
+		t.Fatal("Wrong host throughput", value)
+	}
+
+	value = snapshotValue(exporter.metrics, "newrelic_instance_throughput", "Test/Client/Name", "9045822", "application_summary", "web-1", "9340582")
+	if value != 27.3 {
+		t.Fatal("Wrong instance throughput", value)
+	}
+
+	if snapshotValue(exporter.metrics, "newrelic_call_count", "Test/Client/Name", "9045822", "Datastore/statement/JDBC/messages/insert") != 2 {
+		t.Fatal("Application metric data should still be exported")
+	}
+
+}
+
+// snapshotValue returns the value of a series, given its label values in
+// label name order, or -1 if there is no such series.
+func snapshotValue(s *snapshot, name string, labelValues ...string) float64 {
+	if f, ok := s.families[name]; ok {
+		if series, ok := f.series[strings.Join(labelValues, "\xff")]; ok {
+			return series.value
+		}
+	}
+	return -1
+}
+
+func testServer() *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Header.Get("X-Api-Key") != testApiKey {
+			w.WriteHeader(403)
+		}
+
+		var sourceFile string
+
+		switch r.URL.Path {
+
+		case "/v2/applications.json":
+			sourceFile = "../_testing/application_list.json"
+
+		case "/v2/applications/9045822/metrics.json":
+			if r.URL.Query().Get("page") == "2" {
+				sourceFile = "../_testing/metric_names_2.json"
+			} else {
+				sourceFile = "../_testing/metric_names.json"
+				w.Header().Set("Link", "<"+r.URL.Path+"?page=2>; rel=\"next\"")
+			}
+
+		case "/v2/applications/9045822/metrics/data.json",
+			"/v2/applications/9045822/hosts/2903402/metrics/data.json":
+			sourceFile = "../_testing/metric_data.json"
+
+		case "/v2/applications/9045822/hosts.json":
+			sourceFile = "../_testing/application_hosts.json"
+
+		case "/v2/applications/9045822/instances.json":
+			sourceFile = "../_testing/application_instances.json"
+
+		case "/v2/applications/9045822/deployments.json":
+			sourceFile = "../_testing/deployments.json"
+
+		default:
+			w.WriteHeader(404)
+			return
+
+		}
+
+		body, err := ioutil.ReadFile(sourceFile)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+
+		w.WriteHeader(200)
+		w.Write(body)
+
+	}))
+}
+
+func TestCounters(t *testing.T) {
+
+	ts := testServer()
+	defer ts.Close()
+
+	cfg := config.Config{
+		Account: config.Account{
+			NRApiKey:        testApiKey,
+			NRApiServer:     ts.URL,
+			NRService:       "applications",
+			NRTimeout:       testTimeout,
+			NRMaxRetries:    -1,
+			NRMetricFilters: []string{"Datastore/statement/JDBC/messages"},
+		},
+		MetricCounters: []string{"call_count"},
+	}
+
+	exporter := NewExporter(newrelic.NewAPI(cfg), cfg)
+
+	start := time.Now()
+
+	exporter.poll(context.Background(), time.Minute)
+	exporter.poll(context.Background(), time.Minute)
+
+	if !time.Now().Truncate(time.Minute).Equal(start.Truncate(time.Minute)) {
+		t.Skip("Polls crossed a minute boundary")
+	}
+
+	// Both polls cover the same window, which is only counted once
+	value := snapshotValue(exporter.metrics, "newrelic_call_count_total", "Test/Client/Name", "9045822", "Datastore/statement/JDBC/messages/insert")
+	if value != 2 {
+		t.Fatal("Expected the call count of one window, got", value)
+	}
+
+	// A window overlapping the counted one starts where that ended
+	exporter.cacheMu.Lock()
+	exporter.dataLastTo[9045822] = exporter.dataLastTo[9045822].Add(-30 * time.Second)
+	exporter.cacheMu.Unlock()
+
+	exporter.poll(context.Background(), time.Minute)
+
+	value = snapshotValue(exporter.metrics, "newrelic_call_count_total", "Test/Client/Name", "9045822", "Datastore/statement/JDBC/messages/insert")
+	if value != 4 {
+		t.Fatal("Expected the overlapping window to be counted, got", value)
+	}
+
+	if _, ok := exporter.metrics.families["newrelic_call_count"]; ok {
+		t.Fatal("Counters should not be exported as gauges")
+	}
+
+	lastTo := exporter.dataLastTo[9045822]
+
+	// Incomplete data must neither count nor move the window
+	exporter.cacheMu.Lock()
+	exporter.namesLastScrape[9045822] = time.Time{}
+	exporter.cacheMu.Unlock()
+	ts.Close()
+
+	exporter.poll(context.Background(), time.Minute)
+
+	value = snapshotValue(exporter.metrics, "newrelic_call_count_total", "Test/Client/Name", "9045822", "Datastore/statement/JDBC/messages/insert")
+	if value != 4 || !exporter.dataLastTo[9045822].Equal(lastTo) {
+		t.Fatal("Failed scrape changed the counter or its window", value, exporter.dataLastTo[9045822])
+	}
+
+}
@@ -0,0 +1,255 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mrf/newrelic_exporter/config"
+	"github.com/mrf/newrelic_exporter/newrelic"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_:]+`)
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+type unit struct {
+	suffix string
+	scale  float64
+}
+
+// Units of timeslice values. Times are reported in milliseconds.
+var timesliceUnits = map[string]unit{
+	"average_response_time":  {"_seconds", 0.001},
+	"min_response_time":      {"_seconds", 0.001},
+	"max_response_time":      {"_seconds", 0.001},
+	"average_exclusive_time": {"_seconds", 0.001},
+	"average_call_time":      {"_seconds", 0.001},
+	"standard_deviation":     {"_seconds", 0.001},
+	"throughput":             {"_per_minute", 1},
+}
+
+// Timeslice values folded into the call_duration summary by responseTime
+var responseTimeValues = map[string]bool{
+	"call_count":            true,
+	"average_response_time": true,
+	"min_response_time":     true,
+	"max_response_time":     true,
+	"standard_deviation":    true,
+}
+
+// Units of application summary values, by component. End user response times
+// are reported in seconds, application response times in milliseconds.
+var summaryUnits = map[string]map[string]unit{
+	"application_summary": {
+		"response_time": {"_seconds", 0.001},
+		"throughput":    {"_per_minute", 1},
+	},
+	"end_user_summary": {
+		"response_time": {"_seconds", 1},
+		"throughput":    {"_per_minute", 1},
+	},
+}
+
+// mapper turns New Relic value names and metric paths into Prometheus
+// metric names and labels.
+type mapper struct {
+	unitSuffixes bool
+	summaries    bool
+	counters     map[string]bool
+	timestamps   bool
+	appLabel     string
+	rules        []relabelRule
+	labels       []string
+}
+
+type relabelRule struct {
+	match     *regexp.Regexp
+	prefix    string
+	component string
+}
+
+func newMapper(cfg config.Config) (*mapper, error) {
+	m := &mapper{
+		unitSuffixes: cfg.MetricUnitSuffixes,
+		summaries:    cfg.MetricSummaries,
+		counters:     make(map[string]bool),
+		timestamps:   cfg.MetricTimestamps,
+		appLabel:     cfg.MetricAppLabel,
+	}
+
+	for _, name := range cfg.MetricCounters {
+		m.counters[name] = true
+	}
+	seen := map[string]struct{}{"app": {}, "app_id": {}, "component": {}}
+
+	for _, r := range cfg.MetricRelabelRules {
+		match, err := regexp.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relabel rule %q: %v", r.Match, err)
+		}
+
+		m.rules = append(m.rules, relabelRule{
+			match:     match,
+			prefix:    r.Prefix,
+			component: r.Component,
+		})
+
+		for _, name := range match.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			m.labels = append(m.labels, name)
+		}
+	}
+
+	return m, nil
+}
+
+// app sets the app label of an application to its name, ID or both, as
+// metrics.app-label selects, and the app_id label to its ID.
+func (m *mapper) app(labels prometheus.Labels, app newrelic.Application) prometheus.Labels {
+	id := strconv.Itoa(app.ID)
+
+	switch m.appLabel {
+	case "id":
+		labels["app"] = id
+	case "both":
+		labels["app"] = fmt.Sprintf("%s (%s)", app.Name, id)
+	default:
+		labels["app"] = app.Name
+	}
+	labels["app_id"] = id
+
+	return labels
+}
+
+// summary maps an application summary value.
+func (m *mapper) summary(component, name string, value float64) (string, float64) {
+	return m.unit(summaryUnits[component], name, value)
+}
+
+// timeslice maps a timeslice value of the metric at path.
+func (m *mapper) timeslice(path, name string, value float64) (string, float64, prometheus.Labels) {
+	name, value = m.unit(timesliceUnits, name, value)
+	prefix, labels := m.relabel(path)
+
+	return prefix + name, value, labels
+}
+
+// responseTime folds the timeslice values of the metric at path that
+// describe its response time distribution into a call_duration summary,
+// with the minimum and maximum as quantiles 0 and 1, and a gauge of its
+// standard deviation. It returns false unless all of them are present.
+func (m *mapper) responseTime(path string, values map[string]interface{}) ([]Metric, bool) {
+	v := make(map[string]float64, len(responseTimeValues))
+	for name := range responseTimeValues {
+		f, ok := values[name].(float64)
+		if !ok {
+			return nil, false
+		}
+		v[name] = f
+	}
+
+	// Times are reported in milliseconds
+	suffix, scale := "", 1.0
+	if m.unitSuffixes {
+		suffix, scale = "_seconds", 0.001
+	}
+
+	prefix, labels := m.relabel(path)
+	count := v["call_count"]
+
+	return []Metric{
+		{
+			Name:   prefix + "call_duration" + suffix,
+			Help:   "Response time of calls: call_count, total time and min_response_time and max_response_time as quantiles 0 and 1.",
+			Labels: labels,
+			Summary: &Summary{
+				Count: uint64(count),
+				Sum:   v["average_response_time"] * count * scale,
+				Quantiles: map[float64]float64{
+					0: v["min_response_time"] * scale,
+					1: v["max_response_time"] * scale,
+				},
+			},
+		},
+		{
+			Name:   prefix + "call_duration_standard_deviation" + suffix,
+			Help:   "Standard deviation of the response time of calls.",
+			Value:  v["standard_deviation"] * scale,
+			Labels: labels,
+		},
+	}, true
+}
+
+// relabel returns the name prefix and the labels of the metric at path.
+// Every label named by a relabel rule is set, empty unless the first
+// matching rule fills it, so that all series of a metric share one label set.
+func (m *mapper) relabel(path string) (string, prometheus.Labels) {
+	prefix := ""
+	labels := prometheus.Labels{"component": path}
+	for _, l := range m.labels {
+		labels[l] = ""
+	}
+
+	for _, r := range m.rules {
+		match := r.match.FindStringSubmatchIndex(path)
+		if match == nil {
+			continue
+		}
+
+		for i, l := range r.match.SubexpNames() {
+			if l != "" && match[2*i] >= 0 {
+				labels[l] = path[match[2*i]:match[2*i+1]]
+			}
+		}
+
+		if r.component != "" {
+			labels["component"] = string(r.match.ExpandString(nil, r.component, path, match))
+		}
+
+		prefix = r.prefix
+		break
+	}
+
+	return prefix, labels
+}
+
+func (m *mapper) unit(units map[string]unit, name string, value float64) (string, float64) {
+	if !m.unitSuffixes {
+		return name, value
+	}
+
+	if u, ok := units[name]; ok {
+		value *= u.scale
+		if !strings.HasSuffix(name, u.suffix) {
+			name += u.suffix
+		}
+	}
+
+	return name, value
+}
+
+// sanitizeName replaces characters not allowed in metric names.
+func sanitizeName(name string) string {
+	return sanitize(invalidNameChars, name)
+}
+
+// sanitizeLabel replaces characters not allowed in label names.
+func sanitizeLabel(name string) string {
+	return sanitize(invalidLabelChars, name)
+}
+
+func sanitize(invalid *regexp.Regexp, name string) string {
+	name = invalid.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
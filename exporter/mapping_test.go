@@ -0,0 +1,138 @@
+package exporter
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrf/newrelic_exporter/config"
+	"github.com/mrf/newrelic_exporter/newrelic"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMapper(t *testing.T) {
+
+	m, err := newMapper(config.Config{
+		MetricUnitSuffixes: true,
+		MetricRelabelRules: []config.RelabelRule{
+			{
+				Match:     "^Datastore/statement/(?P<datastore>[^/]+)/(?P<table>[^/]+)/(?P<operation>[^/]+)$",
+				Prefix:    "datastore_",
+				Component: "Datastore/statement",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, value, labels := m.timeslice("Datastore/statement/JDBC/messages/insert", "average_response_time", 200)
+
+	if name != "datastore_average_response_time_seconds" || value != 0.2 {
+		t.Fatal("Wrong name or value", name, value)
+	}
+
+	if labels["datastore"] != "JDBC" || labels["table"] != "messages" || labels["operation"] != "insert" || labels["component"] != "Datastore/statement" {
+		t.Fatal("Wrong labels", labels)
+	}
+
+	name, _, labels = m.timeslice("WebTransaction/Controller/messages", "calls_per_minute", 2)
+
+	if name != "calls_per_minute" || len(labels) != 4 || labels["table"] != "" {
+		t.Fatal("Unmatched paths should keep their name and get empty rule labels", name, labels)
+	}
+
+	if name, value := m.summary("end_user_summary", "response_time", 4.61); name != "response_time_seconds" || value != 4.61 {
+		t.Fatal("Wrong end user response time", name, value)
+	}
+
+	for in, out := range map[string]string{
+		"average_response_time":  "average_response_time",
+		"percentile.duration.95": "percentile_duration_95",
+		"95th":                   "_95th",
+		"Apdex/score (s)":        "Apdex_score_s_",
+	} {
+		if got := sanitizeName(in); got != out {
+			t.Fatalf("sanitizeName(%q) = %q, expected %q", in, got, out)
+		}
+	}
+
+}
+
+func TestAppLabel(t *testing.T) {
+
+	app := newrelic.Application{ID: 9045822, Name: "Checkout"}
+
+	for appLabel, expected := range map[string]string{
+		"":     "Checkout",
+		"name": "Checkout",
+		"id":   "9045822",
+		"both": "Checkout (9045822)",
+	} {
+		m, err := newMapper(config.Config{MetricAppLabel: appLabel})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		labels := m.app(prometheus.Labels{}, app)
+		if labels["app"] != expected || labels["app_id"] != "9045822" {
+			t.Fatalf("Wrong labels for metrics.app-label %q: %v", appLabel, labels)
+		}
+	}
+
+}
+
+func TestResponseTimeSummary(t *testing.T) {
+
+	ts := testServer()
+	defer ts.Close()
+
+	exporter := testExporter(ts.URL)
+	exporter.cfg.MetricUnitSuffixes = true
+	exporter.cfg.MetricSummaries = true
+	exporter.mapper, _ = newMapper(exporter.cfg)
+
+	exporter.poll(context.Background(), time.Minute)
+
+	expected := `
+# HELP newrelic_call_duration_seconds Response time of calls: call_count, total time and min_response_time and max_response_time as quantiles 0 and 1.
+# TYPE newrelic_call_duration_seconds summary
+newrelic_call_duration_seconds{app="Test/Client/Name",app_id="9045822",component="Datastore/statement/JDBC/messages/insert",quantile="0"} 0.091
+newrelic_call_duration_seconds{app="Test/Client/Name",app_id="9045822",component="Datastore/statement/JDBC/messages/insert",quantile="1"} 0.291
+newrelic_call_duration_seconds_sum{app="Test/Client/Name",app_id="9045822",component="Datastore/statement/JDBC/messages/insert"} 0.4
+newrelic_call_duration_seconds_count{app="Test/Client/Name",app_id="9045822",component="Datastore/statement/JDBC/messages/insert"} 2
+# HELP newrelic_call_duration_standard_deviation_seconds Standard deviation of the response time of calls.
+# TYPE newrelic_call_duration_standard_deviation_seconds gauge
+newrelic_call_duration_standard_deviation_seconds{app="Test/Client/Name",app_id="9045822",component="Datastore/statement/JDBC/messages/insert"} 0.0746
+`
+
+	err := testutil.CollectAndCompare(exporter.metrics, strings.NewReader(expected), "newrelic_call_duration_seconds", "newrelic_call_duration_standard_deviation_seconds", "newrelic_call_count", "newrelic_min_response_time_seconds")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Values outside the summary are kept
+	if value := snapshotValue(exporter.metrics, "newrelic_calls_per_minute", "Test/Client/Name", "9045822", "Datastore/statement/JDBC/messages/insert"); value != 2.03 {
+		t.Fatal("Wrong calls_per_minute", value)
+	}
+
+	// Count and sum accumulate over windows like counters
+	exporter.cacheMu.Lock()
+	exporter.dataLastTo[9045822] = exporter.dataLastTo[9045822].Add(-time.Minute)
+	exporter.cacheMu.Unlock()
+
+	exporter.poll(context.Background(), time.Minute)
+
+	series := exporter.metrics.families["newrelic_call_duration_seconds"].series
+	if len(series) == 0 {
+		t.Fatal("Expected call_duration series")
+	}
+	for _, s := range series {
+		if s.summary.Count != 4 || s.summary.Sum < 0.79 || s.summary.Sum > 0.81 {
+			t.Fatal("Expected the summary to accumulate, got", s.summary)
+		}
+	}
+
+}
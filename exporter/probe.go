@@ -0,0 +1,102 @@
+package exporter
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/mrf/newrelic_exporter/config"
+	"github.com/mrf/newrelic_exporter/newrelic"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+// Probe scrapes the metric data of one application for the last full period
+// before api.data-lag and returns a registry holding only its series, along
+// with the success and duration of the probe. The module selects metric names
+// and values; without filters the account's are used. The probe bypasses the
+// snapshot and shares the account's API limits. Values of metrics.counters
+// are exported as gauges of the period. The series of a named account carry
+// the account label.
+func (e *Exporter) Probe(ctx context.Context, appID int, module config.ProbeModule) *prometheus.Registry {
+	startTime := time.Now()
+
+	api, cfg, mapper := e.settings()
+
+	from, to := window(startTime, scrapePeriod(cfg), cfg.NRDataLag)
+
+	if len(module.MetricFilters) > 0 {
+		api = api.WithFilters(module.MetricFilters, module.Values)
+	}
+
+	app := e.application(appID)
+	success := 1.0
+
+	names, err := api.GetMetricNames(ctx, appID)
+	if err != nil {
+		log.Errorf("Probe of app %v: %v", appID, err)
+		success = 0
+	}
+
+	data, err := api.GetMetricData(ctx, appID, names, from, to)
+	if err != nil {
+		log.Errorf("Probe of app %v: %v", appID, err)
+		success = 0
+	}
+
+	metricChan := make(chan Metric)
+
+	go func() {
+		sendMetricData(mapper, app, "", data, countersAsGauges, metricChan)
+		close(metricChan)
+	}()
+
+	var metrics []Metric
+	for metric := range metricChan {
+		metrics = append(metrics, metric)
+	}
+
+	s := newSnapshot()
+	s.receive(metrics)
+
+	// Series carry the account label like those of the account's registry
+	registry := prometheus.NewRegistry()
+	var registerer prometheus.Registerer = registry
+	if cfg.Name != "" {
+		registerer = prometheus.WrapRegistererWith(prometheus.Labels{"account": cfg.Name}, registry)
+	}
+	registerer.MustRegister(s)
+
+	probeSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: NameSpace,
+		Name:      "probe_success",
+		Help:      "Whether all API requests of the probe succeeded.",
+	})
+	probeSuccess.Set(success)
+
+	probeDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: NameSpace,
+		Name:      "probe_duration_seconds",
+		Help:      "Duration of the probe.",
+	})
+	probeDuration.Set(time.Since(startTime).Seconds())
+
+	registerer.MustRegister(probeSuccess, probeDuration)
+
+	return registry
+}
+
+// application returns the cached application with the given ID. Applications
+// outside the cached list are named after their ID.
+func (e *Exporter) application(id int) newrelic.Application {
+	e.cacheMu.Lock()
+	defer e.cacheMu.Unlock()
+
+	for _, app := range e.apps {
+		if app.ID == id {
+			return app
+		}
+	}
+
+	return newrelic.Application{ID: id, Name: strconv.Itoa(id)}
+}
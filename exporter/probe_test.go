@@ -0,0 +1,80 @@
+package exporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mrf/newrelic_exporter/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProbe(t *testing.T) {
+
+	ts := testServer()
+	defer ts.Close()
+
+	exporter := testExporter(ts.URL)
+
+	// Apps are named after their ID until the app list has been scraped
+	registry := exporter.Probe(context.Background(), 9045822, config.ProbeModule{})
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		switch family.GetName() {
+		case "newrelic_probe_success":
+			if v := family.GetMetric()[0].GetGauge().GetValue(); v != 1 {
+				t.Fatal("Expected a successful probe, got", v)
+			}
+		case "newrelic_call_count":
+			if app := family.GetMetric()[0].GetLabel()[0]; app.GetName() != "app" || app.GetValue() != "9045822" {
+				t.Fatal("Wrong app label", app)
+			}
+		}
+	}
+
+	// The metric data gauges plus the probe success and duration
+	if n, err := testutil.GatherAndCount(registry); err != nil || n != 12 {
+		t.Fatal("Expected 12 probed metrics, got", n, err)
+	}
+
+	exporter.poll(context.Background(), time.Minute)
+
+	registry = exporter.Probe(context.Background(), 9045822, config.ProbeModule{
+		MetricFilters: []string{"Datastore/statement/JDBC/messages/insert"},
+		Values:        []string{"call_count"},
+	})
+
+	families, err = registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() == "newrelic_call_count" {
+			if app := family.GetMetric()[0].GetLabel()[0]; app.GetValue() != "Test/Client/Name" {
+				t.Fatal("Expected the cached app name, got", app.GetValue())
+			}
+		}
+	}
+
+	exporter.cfg.Name = "shop-eu"
+
+	families, err = exporter.Probe(context.Background(), 9045822, config.ProbeModule{}).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			if account := metric.GetLabel()[0]; account.GetName() != "account" || account.GetValue() != "shop-eu" {
+				t.Fatal("Expected the account label on", family.GetName(), metric.GetLabel())
+			}
+		}
+	}
+
+}
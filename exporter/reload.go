@@ -0,0 +1,74 @@
+package exporter
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/mrf/newrelic_exporter/config"
+	"github.com/mrf/newrelic_exporter/newrelic"
+	"github.com/prometheus/log"
+)
+
+// PrepareReload builds the API client and metric mapping of a validated
+// configuration of the same account and returns the function that switches
+// the exporter to them. Nothing changes before it is called, so that several
+// exporters can be switched together once all of them are prepared. The
+// cached application list and metric names are kept unless the settings they
+// depend on changed, and the snapshot is dropped when the metric mapping
+// changed. A running scrape finishes with the old settings.
+func (e *Exporter) PrepareReload(cfg config.Config) (func(), error) {
+	m, err := newMapper(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse metric mapping: %v", err)
+	}
+
+	current, _, _ := e.settings()
+
+	api, err := current.WithConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { e.switchTo(api, cfg, m) }, nil
+}
+
+// switchTo makes the prepared client, configuration and mapping the ones in
+// effect and drops what they invalidate.
+func (e *Exporter) switchTo(api newrelic.Client, cfg config.Config, m *mapper) {
+	e.settingsMu.Lock()
+	defer e.settingsMu.Unlock()
+
+	api.ApplyLimits()
+
+	old := e.cfg
+	e.api, e.cfg, e.mapper = api, cfg, m
+
+	sameSource := old.NRBackend == cfg.NRBackend &&
+		old.NRApiServer == cfg.NRApiServer &&
+		old.NRApiKey == cfg.NRApiKey &&
+		old.NRAccountID == cfg.NRAccountID &&
+		old.NRService == cfg.NRService
+
+	e.cacheMu.Lock()
+	if !sameSource || !reflect.DeepEqual(old.NRApps, cfg.NRApps) || !reflect.DeepEqual(old.NRExcludeApps, cfg.NRExcludeApps) {
+		log.Info("Application list invalidated by reload")
+		e.appListLastScrape = time.Time{}
+	}
+	if !sameSource {
+		e.lastDeployment = make(map[int]time.Time)
+	}
+	if !sameSource || !reflect.DeepEqual(old.NRMetricFilters, cfg.NRMetricFilters) {
+		log.Info("Metric names invalidated by reload")
+		e.names = make(map[int][]newrelic.MetricName)
+		e.namesLastScrape = make(map[int]time.Time)
+	}
+	e.cacheMu.Unlock()
+
+	if old.MetricUnitSuffixes != cfg.MetricUnitSuffixes || old.MetricSummaries != cfg.MetricSummaries || !reflect.DeepEqual(old.MetricCounters, cfg.MetricCounters) || old.MetricTimestamps != cfg.MetricTimestamps || old.MetricAppLabel != cfg.MetricAppLabel || !reflect.DeepEqual(old.MetricRelabelRules, cfg.MetricRelabelRules) {
+		log.Info("Snapshot dropped as the metric mapping changed")
+		e.mu.Lock()
+		e.metrics = newSnapshot()
+		e.mu.Unlock()
+	}
+}
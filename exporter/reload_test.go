@@ -0,0 +1,81 @@
+package exporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mrf/newrelic_exporter/config"
+)
+
+func TestReloadKeepsCaches(t *testing.T) {
+
+	ts := testServer()
+	defer ts.Close()
+
+	exporter := testExporter(ts.URL)
+
+	exporter.poll(context.Background(), time.Minute)
+
+	reload := func(cfg config.Config) error {
+		commit, err := exporter.PrepareReload(cfg)
+		if err != nil {
+			return err
+		}
+		commit()
+		return nil
+	}
+
+	_, cfg, _ := exporter.settings()
+	cfg.NRPeriod = 120
+
+	// Nothing changes until the reload is committed
+	commit, err := exporter.PrepareReload(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, current, _ := exporter.settings(); current.NRPeriod == 120 {
+		t.Fatal("A prepared reload should not be applied yet")
+	}
+
+	commit()
+
+	if len(exporter.names) != 1 || exporter.appListLastScrape.IsZero() || len(exporter.metrics.families) == 0 {
+		t.Fatal("Expected caches and snapshot to be kept")
+	}
+
+	cfg.NRMetricFilters = []string{"Datastore/statement/JDBC"}
+
+	err = reload(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(exporter.names) != 0 || exporter.appListLastScrape.IsZero() {
+		t.Fatal("Expected only the metric names to be dropped")
+	}
+
+	cfg.MetricUnitSuffixes = true
+	cfg.NRApiServer = "http://localhost:1"
+
+	err = reload(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !exporter.appListLastScrape.IsZero() || len(exporter.metrics.families) != 0 {
+		t.Fatal("Expected the application list and snapshot to be dropped")
+	}
+
+	cfg.NRService = ""
+
+	if err = reload(cfg); err == nil {
+		t.Fatal("Expected an error without a service")
+	}
+
+	if _, current, _ := exporter.settings(); current.NRService != "applications" {
+		t.Fatal("A failed reload should keep the settings")
+	}
+
+}
@@ -0,0 +1,231 @@
+package exporter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+// Summary is the value of a summary-shaped metric.
+type Summary struct {
+	Count     uint64
+	Sum       float64
+	Quantiles map[float64]float64
+}
+
+// snapshot holds the series of scraped metrics and serves them as constant
+// metrics. All series of a metric share its label names and type. Counters
+// accumulate the values they receive.
+type snapshot struct {
+	families map[string]*family
+	// Number of receive calls, by which series count missed cycles
+	cycle uint64
+}
+
+// family is a metric and its series, by label values.
+type family struct {
+	desc       *prometheus.Desc
+	labelNames []string
+	summary    bool
+	counter    bool
+	series     map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	summary     *Summary
+	timestamp   time.Time
+	// Cycle and time the series was last received
+	cycle    uint64
+	lastSeen time.Time
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{families: make(map[string]*family)}
+}
+
+// receive stores the metrics of one cycle, replacing series with the same
+// labels or, for counters, adding to them.
+func (s *snapshot) receive(metrics []Metric) {
+	s.cycle++
+
+	for _, metric := range metrics {
+		if err := s.add(metric); err != nil {
+			log.Warnf("Dropping %s: %v", metric.Name, err)
+		}
+	}
+}
+
+func (s *snapshot) add(metric Metric) error {
+	name := fmt.Sprintf("%s_%s", NameSpace, sanitizeName(metric.Name))
+
+	labels := make(prometheus.Labels, len(metric.Labels))
+	for l, v := range metric.Labels {
+		labels[sanitizeLabel(l)] = v
+	}
+
+	f, ok := s.families[name]
+	if !ok {
+		labelNames := make([]string, 0, len(labels))
+		for l := range labels {
+			labelNames = append(labelNames, l)
+		}
+		sort.Strings(labelNames)
+
+		f = &family{
+			desc:       prometheus.NewDesc(name, metric.Help, labelNames, nil),
+			labelNames: labelNames,
+			summary:    metric.Summary != nil,
+			counter:    metric.Counter,
+			series:     make(map[string]*series),
+		}
+		s.families[name] = f
+	}
+
+	if f.summary != (metric.Summary != nil) || f.counter != metric.Counter {
+		return fmt.Errorf("%s was received with another type", name)
+	}
+
+	if len(labels) != len(f.labelNames) {
+		return fmt.Errorf("label names of %s are %v", name, f.labelNames)
+	}
+
+	values := make([]string, len(f.labelNames))
+	for i, l := range f.labelNames {
+		v, ok := labels[l]
+		if !ok {
+			return fmt.Errorf("label names of %s are %v", name, f.labelNames)
+		}
+		values[i] = v
+	}
+
+	key := strings.Join(values, "\xff")
+
+	if len(metric.ReplaceBy) > 0 {
+		f.replace(key, labels, metric.ReplaceBy)
+	}
+
+	old, ok := f.series[key]
+	if ok && f.counter {
+		metric.Value += old.value
+		if metric.Summary != nil {
+			metric.Summary = &Summary{
+				Count:     old.summary.Count + metric.Summary.Count,
+				Sum:       old.summary.Sum + metric.Summary.Sum,
+				Quantiles: metric.Summary.Quantiles,
+			}
+		}
+	}
+
+	f.series[key] = &series{
+		labelValues: values,
+		value:       metric.Value,
+		summary:     metric.Summary,
+		timestamp:   metric.Timestamp,
+		cycle:       s.cycle,
+		lastSeen:    time.Now(),
+	}
+
+	return nil
+}
+
+// evict drops the series that were not received in the last cycles cycles
+// or for longer than ttl, and metrics left without series. Zero disables
+// either limit. It returns the number of dropped series.
+func (s *snapshot) evict(cycles int, ttl time.Duration) int {
+	if cycles <= 0 && ttl <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	evicted := 0
+
+	for name, f := range s.families {
+		for key, series := range f.series {
+			if (cycles > 0 && s.cycle-series.cycle >= uint64(cycles)) ||
+				(ttl > 0 && now.Sub(series.lastSeen) > ttl) {
+				delete(f.series, key)
+				evicted++
+			}
+		}
+
+		if len(f.series) == 0 {
+			delete(s.families, name)
+		}
+	}
+
+	return evicted
+}
+
+// len returns the number of series.
+func (s *snapshot) len() int {
+	n := 0
+	for _, f := range s.families {
+		n += len(f.series)
+	}
+	return n
+}
+
+// replace drops the series other than key with the same values of the
+// labels by as labels.
+func (f *family) replace(key string, labels prometheus.Labels, by []string) {
+	for k, series := range f.series {
+		if k == key {
+			continue
+		}
+
+		same := true
+		for _, l := range by {
+			for i, name := range f.labelNames {
+				if name == l && series.labelValues[i] != labels[l] {
+					same = false
+				}
+			}
+		}
+
+		if same {
+			delete(f.series, k)
+		}
+	}
+}
+
+func (s *snapshot) Describe(ch chan<- *prometheus.Desc) {
+	for _, f := range s.families {
+		ch <- f.desc
+	}
+}
+
+func (s *snapshot) Collect(ch chan<- prometheus.Metric) {
+	for _, f := range s.families {
+		for _, series := range f.series {
+			ch <- f.metric(series)
+		}
+	}
+}
+
+func (f *family) metric(s *series) prometheus.Metric {
+	var m prometheus.Metric
+	var err error
+
+	switch {
+	case s.summary != nil:
+		m, err = prometheus.NewConstSummary(f.desc, s.summary.Count, s.summary.Sum, s.summary.Quantiles, s.labelValues...)
+	case f.counter:
+		m, err = prometheus.NewConstMetric(f.desc, prometheus.CounterValue, s.value, s.labelValues...)
+	default:
+		m, err = prometheus.NewConstMetric(f.desc, prometheus.GaugeValue, s.value, s.labelValues...)
+	}
+
+	if err != nil {
+		return prometheus.NewInvalidMetric(f.desc, err)
+	}
+	if !s.timestamp.IsZero() {
+		return prometheus.NewMetricWithTimestamp(s.timestamp, m)
+	}
+	return m
+}
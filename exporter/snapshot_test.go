@@ -0,0 +1,72 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestEvict(t *testing.T) {
+
+	s := newSnapshot()
+
+	gone := Metric{Name: "call_count", Value: 1, Labels: prometheus.Labels{"app": "gone"}}
+	kept := Metric{Name: "call_count", Value: 1, Labels: prometheus.Labels{"app": "kept"}}
+	other := Metric{Name: "error_count", Value: 1, Labels: prometheus.Labels{"app": "gone"}}
+
+	s.receive([]Metric{gone, kept, other})
+	s.receive([]Metric{kept})
+
+	if n := s.evict(2, 0); n != 0 || s.len() != 3 {
+		t.Fatal("Series missing one cycle should be kept, evicted", n)
+	}
+
+	s.receive([]Metric{kept})
+
+	if n := s.evict(2, 0); n != 2 || s.len() != 1 {
+		t.Fatal("Expected two series missing two cycles to be evicted, evicted", n)
+	}
+
+	if snapshotValue(s, "newrelic_call_count", "kept") != 1 {
+		t.Fatal("Received series was evicted")
+	}
+
+	if _, ok := s.families["newrelic_error_count"]; ok {
+		t.Fatal("Metric without series should be dropped")
+	}
+
+	s.families["newrelic_call_count"].series["kept"].lastSeen = time.Now().Add(-time.Hour)
+
+	if n := s.evict(0, time.Minute); n != 1 || s.len() != 0 {
+		t.Fatal("Expected the series older than the TTL to be evicted, evicted", n)
+	}
+
+	if n := s.evict(0, 0); n != 0 {
+		t.Fatal("Eviction should be disabled, evicted", n)
+	}
+
+}
+
+func TestReplaceBy(t *testing.T) {
+
+	s := newSnapshot()
+
+	deployment := func(app, revision string) Metric {
+		return Metric{
+			Name:      "app_last_deployment_timestamp_seconds",
+			Value:     1,
+			Labels:    prometheus.Labels{"app_id": app, "revision": revision},
+			ReplaceBy: []string{"app_id"},
+		}
+	}
+
+	s.receive([]Metric{deployment("1", "a"), deployment("2", "a")})
+	s.receive([]Metric{deployment("1", "b")})
+
+	if s.len() != 2 || snapshotValue(s, "newrelic_app_last_deployment_timestamp_seconds", "1", "b") != 1 ||
+		snapshotValue(s, "newrelic_app_last_deployment_timestamp_seconds", "2", "a") != 1 {
+		t.Fatal("Expected revision a of app 1 to be replaced only")
+	}
+
+}
@@ -0,0 +1,50 @@
+package newrelic
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Errors holds the errors of the requests made by one call, such as one per
+// metric filter or chunk of metric names. The results of the requests that
+// succeeded are still returned along with it.
+type Errors []error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// errorCollector gathers the errors of concurrent requests.
+type errorCollector struct {
+	mu   sync.Mutex
+	errs Errors
+}
+
+// add records *err, if set, prefixed with the formatted context. It is meant
+// to be deferred by functions with a named error result.
+func (c *errorCollector) add(err *error, format string, args ...interface{}) {
+	if *err == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.errs = append(c.errs, fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), *err))
+}
+
+// err returns the collected errors, or nil if there were none.
+func (c *errorCollector) err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return c.errs
+}
@@ -0,0 +1,141 @@
+package newrelic
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mrf/newrelic_exporter/config"
+)
+
+// appFilter selects applications by api.include-apps and api.exclude-apps.
+// An empty include list selects every application.
+type appFilter struct {
+	include, exclude []appMatcher
+}
+
+type appMatcher struct {
+	id      int
+	name    string
+	pattern *regexp.Regexp
+}
+
+func newAppFilter(include, exclude []config.Application) (*appFilter, error) {
+	f := &appFilter{}
+
+	for _, a := range include {
+		m, err := newAppMatcher(a)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, m)
+	}
+
+	for _, a := range exclude {
+		m, err := newAppMatcher(a)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, m)
+	}
+
+	return f, nil
+}
+
+func newAppMatcher(a config.Application) (appMatcher, error) {
+	switch {
+
+	case a.Id != 0:
+		return appMatcher{id: a.Id}, nil
+
+	case a.Name != "":
+		return appMatcher{name: a.Name}, nil
+
+	case a.Glob != "":
+		// Application names contain slashes, so '*' has to match them too.
+		expr := regexp.QuoteMeta(a.Glob)
+		expr = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(expr)
+		return appMatcher{pattern: regexp.MustCompile("^" + expr + "$")}, nil
+
+	case a.Regex != "":
+		pattern, err := regexp.Compile(a.Regex)
+		if err != nil {
+			return appMatcher{}, fmt.Errorf("invalid application regex %q: %v", a.Regex, err)
+		}
+		return appMatcher{pattern: pattern}, nil
+
+	}
+
+	return appMatcher{}, fmt.Errorf("application filter needs one of id, name, glob or regex")
+}
+
+func (m appMatcher) match(app Application) bool {
+	switch {
+	case m.id != 0:
+		return app.ID == m.id
+	case m.pattern != nil:
+		return m.pattern.MatchString(app.Name)
+	default:
+		return app.Name == m.name
+	}
+}
+
+func (f *appFilter) Match(app Application) bool {
+	for _, m := range f.exclude {
+		if m.match(app) {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+
+	for _, m := range f.include {
+		if m.match(app) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Filter returns the selected applications, dropping duplicates.
+func (f *appFilter) Filter(apps []Application) []Application {
+	filtered := make([]Application, 0, len(apps))
+	seen := make(map[int]struct{})
+
+	for _, app := range apps {
+		if _, ok := seen[app.ID]; ok {
+			continue
+		}
+		seen[app.ID] = struct{}{}
+
+		if f.Match(app) {
+			filtered = append(filtered, app)
+		}
+	}
+
+	return filtered
+}
+
+// exact returns the IDs and names of the include list if every entry is an
+// exact ID or name, so that the API can do the filtering.
+func (f *appFilter) exact() (ids []int, names []string, ok bool) {
+	if len(f.include) == 0 {
+		return nil, nil, false
+	}
+
+	for _, m := range f.include {
+		switch {
+		case m.pattern != nil:
+			return nil, nil, false
+		case m.id != 0:
+			ids = append(ids, m.id)
+		default:
+			names = append(names, m.name)
+		}
+	}
+
+	return ids, names, true
+}
@@ -0,0 +1,62 @@
+package newrelic
+
+import (
+	"testing"
+
+	"github.com/mrf/newrelic_exporter/config"
+)
+
+func TestAppFilter(t *testing.T) {
+
+	f, err := newAppFilter(
+		[]config.Application{
+			{Id: 1},
+			{Name: "Checkout"},
+			{Glob: "payments/*"},
+			{Regex: "^team-a-"},
+		},
+		[]config.Application{
+			{Glob: "*-staging"},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apps := []Application{
+		{ID: 1, Name: "by-id"},
+		{ID: 2, Name: "Checkout"},
+		{ID: 3, Name: "payments/api/v2"},
+		{ID: 4, Name: "team-a-search"},
+		{ID: 5, Name: "team-a-search-staging"},
+		{ID: 6, Name: "Checkout2"},
+		{ID: 1, Name: "by-id"},
+	}
+
+	filtered := f.Filter(apps)
+
+	if len(filtered) != 4 {
+		t.Fatal("Expected 4 applications, got", filtered)
+	}
+
+	for i, id := range []int{1, 2, 3, 4} {
+		if filtered[i].ID != id {
+			t.Fatal("Expected application", id, "got", filtered[i].ID)
+		}
+	}
+
+	if _, _, ok := f.exact(); ok {
+		t.Fatal("Globs and regexes cannot be filtered by the API")
+	}
+
+	f, _ = newAppFilter([]config.Application{{Id: 1}, {Name: "Checkout"}}, nil)
+
+	ids, names, ok := f.exact()
+	if !ok || len(ids) != 1 || len(names) != 1 {
+		t.Fatal("Expected exact ID and name filters", ids, names)
+	}
+
+	if _, err := newAppFilter([]config.Application{{Regex: "("}}, nil); err == nil {
+		t.Fatal("Expected an invalid regex to fail")
+	}
+
+}
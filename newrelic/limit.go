@@ -0,0 +1,200 @@
+package newrelic
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mrf/newrelic_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// Length of the window of api.hourly-call-budget
+const BudgetWindow = time.Hour
+
+// ErrBudgetExhausted is returned for requests over api.hourly-call-budget.
+var ErrBudgetExhausted = errors.New("hourly API call budget exhausted")
+
+// Budget is the state of the API call budget.
+type Budget struct {
+	// Calls made since the exporter started
+	Calls int64
+	// Calls left in the current window, -1 without a budget
+	Remaining int
+	// End of the current window
+	Reset time.Time
+}
+
+// limiter caps the request rate, the requests in flight and the number of
+// requests per budget window. Zero settings disable the respective limit.
+type limiter struct {
+	mu          sync.Mutex
+	rate        *rate.Limiter
+	inFlight    chan struct{}
+	budget      int
+	calls       int64
+	used        int
+	windowStart time.Time
+
+	callsTotal, exhausted prometheus.Counter
+	remaining             prometheus.GaugeFunc
+}
+
+func newLimiter(cfg config.Config) *limiter {
+	l := &limiter{
+		callsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "newrelic_exporter_api_calls_total",
+			Help: "API requests made.",
+		}),
+		exhausted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "newrelic_exporter_api_budget_exhausted_total",
+			Help: "API requests not made because the hourly call budget was exhausted.",
+		}),
+	}
+
+	l.remaining = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "newrelic_exporter_api_calls_remaining",
+		Help: "API requests left in the current hourly call budget.",
+	}, func() float64 {
+		return float64(l.Budget().Remaining)
+	})
+
+	l.configure(cfg)
+
+	return l
+}
+
+// configure applies the limits of cfg. The budget window and the calls made
+// are kept. Requests in flight are not counted against a changed in-flight
+// limit.
+func (l *limiter) configure(cfg config.Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.budget = cfg.NRHourlyCallBudget
+
+	burst := cfg.NRRateBurst
+	if burst <= 0 {
+		burst = 1
+	}
+
+	switch {
+	case cfg.NRRateLimit <= 0:
+		l.rate = nil
+	case l.rate == nil:
+		l.rate = rate.NewLimiter(rate.Limit(cfg.NRRateLimit), burst)
+	default:
+		l.rate.SetLimit(rate.Limit(cfg.NRRateLimit))
+		l.rate.SetBurst(burst)
+	}
+
+	switch {
+	case cfg.NRMaxInFlight <= 0:
+		l.inFlight = nil
+	case l.inFlight == nil || cap(l.inFlight) != cfg.NRMaxInFlight:
+		l.inFlight = make(chan struct{}, cfg.NRMaxInFlight)
+	}
+}
+
+func (l *limiter) budgeted() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.budget > 0
+}
+
+func (l *limiter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.callsTotal.Desc()
+	if l.budgeted() {
+		ch <- l.exhausted.Desc()
+		ch <- l.remaining.Desc()
+	}
+}
+
+func (l *limiter) Collect(ch chan<- prometheus.Metric) {
+	ch <- l.callsTotal
+	if l.budgeted() {
+		ch <- l.exhausted
+		ch <- l.remaining
+	}
+}
+
+// acquire blocks until a request may be made and returns the function that
+// has to be called once it completes.
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	l.mu.Lock()
+	limiter, inFlight := l.rate, l.inFlight
+	l.mu.Unlock()
+
+	if limiter != nil {
+		if err := limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	release := func() {}
+
+	if inFlight != nil {
+		select {
+		case inFlight <- struct{}{}:
+			release = func() { <-inFlight }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if err := l.spend(); err != nil {
+		release()
+		return nil, err
+	}
+
+	return release, nil
+}
+
+// spend takes one call from the budget.
+func (l *limiter) spend() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rollWindow()
+
+	if l.budget > 0 && l.used >= l.budget {
+		l.exhausted.Inc()
+		return ErrBudgetExhausted
+	}
+
+	l.used++
+	l.calls++
+	l.callsTotal.Inc()
+
+	return nil
+}
+
+// Budget returns the current state of the call budget.
+func (l *limiter) Budget() Budget {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rollWindow()
+
+	b := Budget{
+		Calls:     l.calls,
+		Remaining: -1,
+		Reset:     l.windowStart.Add(BudgetWindow),
+	}
+
+	if l.budget > 0 {
+		b.Remaining = l.budget - l.used
+	}
+
+	return b
+}
+
+func (l *limiter) rollWindow() {
+	if now := time.Now(); now.Sub(l.windowStart) >= BudgetWindow {
+		l.windowStart = now
+		l.used = 0
+	}
+}
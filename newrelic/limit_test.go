@@ -0,0 +1,116 @@
+package newrelic
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mrf/newrelic_exporter/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCallBudget(t *testing.T) {
+
+	var inFlight, maxInFlight int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(`{"metrics":[]}`))
+	}))
+	defer ts.Close()
+
+	api := NewAPI(config.Config{Account: config.Account{
+		NRApiKey:           testApiKey,
+		NRApiServer:        ts.URL,
+		NRService:          "applications",
+		NRTimeout:          testTimeout,
+		NRMetricFilters:    []string{"a", "b", "c", "d", "e", "f"},
+		NRMaxInFlight:      2,
+		NRHourlyCallBudget: 4,
+	}})
+
+	api.GetMetricNames(context.Background(), testApiAppId)
+
+	if maxInFlight > 2 {
+		t.Fatal("Expected at most 2 requests in flight, got", maxInFlight)
+	}
+
+	budget := api.Budget()
+	if budget.Calls != 4 || budget.Remaining != 0 {
+		t.Fatal("Expected 4 calls and an exhausted budget, got", budget)
+	}
+
+	if testutil.ToFloat64(api.exhausted) != 2 {
+		t.Fatal("Expected 2 requests over budget, got", testutil.ToFloat64(api.exhausted))
+	}
+
+}
+
+func TestWithConfigKeepsBudget(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"metrics":[]}`))
+	}))
+	defer ts.Close()
+
+	cfg := config.Config{Account: config.Account{
+		NRApiKey:           testApiKey,
+		NRApiServer:        ts.URL,
+		NRService:          "applications",
+		NRTimeout:          testTimeout,
+		NRMetricFilters:    []string{"a", "b"},
+		NRHourlyCallBudget: 10,
+	}}
+
+	api := NewAPI(cfg)
+	api.GetMetricNames(context.Background(), testApiAppId)
+
+	cfg.NRHourlyCallBudget = 3
+	cfg.NRMetricFilters = []string{"a", "b", "c"}
+
+	reloaded, err := api.WithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if budget := api.Budget(); budget.Remaining != 8 {
+		t.Fatal("Limits should not change before ApplyLimits, got", budget)
+	}
+
+	reloaded.ApplyLimits()
+
+	reloaded.GetMetricNames(context.Background(), testApiAppId)
+
+	budget := api.Budget()
+	if budget.Calls != 3 || budget.Remaining != 0 {
+		t.Fatal("Expected the budget to carry over, got", budget)
+	}
+
+	if testutil.ToFloat64(api.exhausted) != 2 {
+		t.Fatal("Expected 2 requests over the new budget, got", testutil.ToFloat64(api.exhausted))
+	}
+
+	cfg.NRBackend = "nerdgraph"
+
+	if _, err = api.WithConfig(cfg); err == nil {
+		t.Fatal("Expected an error for nerdgraph without an account ID")
+	}
+
+	if budget := api.Budget(); budget.Remaining != 0 {
+		t.Fatal("A failed reload should not change the limits, got", budget)
+	}
+
+}
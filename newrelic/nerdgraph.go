@@ -0,0 +1,483 @@
+package newrelic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrf/newrelic_exporter/config"
+	"github.com/prometheus/log"
+)
+
+// Path of the NerdGraph endpoint relative to api.server
+const GraphQLPath = "/graphql"
+
+// NRQL expressions reproducing the REST v2 timeslice values from the Metric
+// event type. Times are converted from seconds to milliseconds to match REST.
+var timesliceFunctions = map[string]string{
+	"call_count":                 "count(newrelic.timeslice.value)",
+	"calls_per_minute":           "rate(count(newrelic.timeslice.value), 1 minute)",
+	"requests_per_minute":        "rate(count(newrelic.timeslice.value), 1 minute)",
+	"average_response_time":      "average(newrelic.timeslice.value) * 1000",
+	"min_response_time":          "min(newrelic.timeslice.value) * 1000",
+	"max_response_time":          "max(newrelic.timeslice.value) * 1000",
+	"standard_deviation":         "stddev(newrelic.timeslice.value) * 1000",
+	"average_value":              "average(newrelic.timeslice.value)",
+	"total_call_time_per_minute": "rate(sum(newrelic.timeslice.value), 1 minute)",
+}
+
+// Entity alert severities mapped to REST v2 health statuses
+var alertSeverityHealth = map[string]string{
+	"NOT_ALERTING":   "green",
+	"WARNING":        "orange",
+	"CRITICAL":       "red",
+	"NOT_CONFIGURED": "gray",
+}
+
+const applicationsQuery = `query($query: String!, $cursor: String) {
+  actor {
+    entitySearch(query: $query) {
+      results(cursor: $cursor) {
+        nextCursor
+        entities {
+          ... on ApmApplicationEntityOutline {
+            applicationId
+            name
+            alertSeverity
+            reporting
+            language
+            apmSummary { apdexScore errorRate hostCount instanceCount responseTimeAverage throughput }
+            apmBrowserSummary { apdexScore pageLoadThroughput pageLoadTimeAverage }
+          }
+        }
+      }
+    }
+  }
+}`
+
+const nrqlQuery = `query($accountId: Int!, $nrql: Nrql!) {
+  actor {
+    account(id: $accountId) {
+      nrql(query: $nrql) {
+        results
+      }
+    }
+  }
+}`
+
+// GraphQLAPI scrapes applications and timeslice metrics through NerdGraph.
+type GraphQLAPI struct {
+	*retrier
+	server        url.URL
+	apiKey        string
+	accountID     int
+	apps          *appFilter
+	metricFilters []string
+	valueFilters  []string
+	client        *http.Client
+}
+
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+type apmEntity struct {
+	ApplicationID int    `json:"applicationId"`
+	Name          string `json:"name"`
+	AlertSeverity string `json:"alertSeverity"`
+	Reporting     bool   `json:"reporting"`
+	Language      string `json:"language"`
+	ApmSummary    *struct {
+		ApdexScore          float64 `json:"apdexScore"`
+		ErrorRate           float64 `json:"errorRate"`
+		HostCount           float64 `json:"hostCount"`
+		InstanceCount       float64 `json:"instanceCount"`
+		ResponseTimeAverage float64 `json:"responseTimeAverage"`
+		Throughput          float64 `json:"throughput"`
+	} `json:"apmSummary"`
+	ApmBrowserSummary *struct {
+		ApdexScore          float64 `json:"apdexScore"`
+		PageLoadThroughput  float64 `json:"pageLoadThroughput"`
+		PageLoadTimeAverage float64 `json:"pageLoadTimeAverage"`
+	} `json:"apmBrowserSummary"`
+}
+
+func NewGraphQLAPI(cfg config.Config) *GraphQLAPI {
+	api, err := newGraphQLAPI(cfg, newRetrier(cfg))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return api
+}
+
+func newGraphQLAPI(cfg config.Config, r *retrier) (*GraphQLAPI, error) {
+	serverURL, err := url.Parse(cfg.NRApiServer)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse API URL: %v", err)
+	}
+	if cfg.NRApiKey == "" {
+		return nil, errors.New("cannot continue without an API key")
+	}
+	if cfg.NRAccountID == 0 {
+		return nil, errors.New("cannot continue without an account ID for NerdGraph")
+	}
+
+	apps, err := newAppFilter(cfg.NRApps, cfg.NRExcludeApps)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse application filters: %v", err)
+	}
+
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GraphQLAPI{
+		retrier:       r,
+		server:        *serverURL,
+		apiKey:        cfg.NRApiKey,
+		accountID:     cfg.NRAccountID,
+		apps:          apps,
+		metricFilters: cfg.NRMetricFilters,
+		valueFilters:  cfg.NRValueFilters,
+		client:        client,
+	}, nil
+}
+
+func (api *GraphQLAPI) WithConfig(cfg config.Config) (Client, error) {
+	return reconfigure(api.retrier, cfg)
+}
+
+func (api *GraphQLAPI) WithFilters(metricFilters, valueFilters []string) Client {
+	filtered := *api
+	filtered.metricFilters = metricFilters
+	filtered.valueFilters = valueFilters
+	return &filtered
+}
+
+func (api *GraphQLAPI) GetApplications(ctx context.Context) ([]Application, error) {
+	log.Infof("Requesting application list from %s.", api.server.String())
+
+	var applications []Application
+
+	query := fmt.Sprintf("domain = 'APM' AND type = 'APPLICATION' AND accountId = %d", api.accountID)
+
+	// Entity search can only narrow down by exact names, IDs are filtered client-side.
+	if ids, names, ok := api.apps.exact(); ok && len(ids) == 0 {
+		quoted := make([]string, len(names))
+		for i, name := range names {
+			quoted[i] = nrqlString(name)
+		}
+		query += fmt.Sprintf(" AND name IN (%s)", strings.Join(quoted, ", "))
+	}
+
+	vars := map[string]interface{}{
+		"query": query,
+	}
+
+	for {
+		var data struct {
+			Actor struct {
+				EntitySearch struct {
+					Results struct {
+						NextCursor *string     `json:"nextCursor"`
+						Entities   []apmEntity `json:"entities"`
+					} `json:"results"`
+				} `json:"entitySearch"`
+			} `json:"actor"`
+		}
+
+		err := api.query(ctx, applicationsQuery, vars, &data)
+		if err != nil {
+			log.Error("Error getting application list: ", err)
+			return applications, err
+		}
+
+		results := data.Actor.EntitySearch.Results
+
+		for _, entity := range results.Entities {
+			applications = append(applications, entity.application())
+		}
+
+		if results.NextCursor == nil || *results.NextCursor == "" {
+			break
+		}
+
+		vars["cursor"] = *results.NextCursor
+	}
+
+	applications = api.apps.Filter(applications)
+
+	log.Debugf("Found %v applications: %v", len(applications), applications)
+
+	return applications, nil
+}
+
+func (api *GraphQLAPI) GetMetricNames(ctx context.Context, appID int) ([]MetricName, error) {
+	log.Infof("Requesting metrics names for application id %d with %v filters", appID, len(api.metricFilters))
+
+	values := make([]string, 0, len(timesliceFunctions))
+	for v := range timesliceFunctions {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+
+	metricNames := make([]MetricName, 0)
+	seen := make(map[string]struct{})
+	var errs errorCollector
+
+	for _, filter := range api.metricFilters {
+		log.Debugf("Scraping filter %v for app %v", filter, appID)
+
+		results, err := api.nrql(ctx, fmt.Sprintf(
+			"SELECT uniques(metricTimesliceName, 10000) FROM Metric WHERE appId = %d AND metricTimesliceName LIKE %s SINCE 1 day ago",
+			appID, nrqlString(filter+"%")))
+		if err != nil {
+			log.Error("Error getting metric names:", err)
+			errs.add(&err, "app %d filter %q", appID, filter)
+			continue
+		}
+
+		for _, row := range results {
+			names, _ := row["uniques.metricTimesliceName"].([]interface{})
+
+			for _, n := range names {
+				name, ok := n.(string)
+				if !ok {
+					continue
+				}
+				if _, ok := seen[name]; ok {
+					continue
+				}
+				seen[name] = struct{}{}
+
+				metricNames = append(metricNames, MetricName{Name: name, ValueNames: values})
+			}
+
+			log.Debugf("Found %v possible metric names for app %v and filter %v", len(names), appID, filter)
+		}
+	}
+
+	return metricNames, errs.err()
+}
+
+func (api *GraphQLAPI) GetMetricData(ctx context.Context, appId int, names []MetricName, from time.Time, to time.Time) ([]MetricData, error) {
+	var selects []string
+
+	for _, v := range valueNames(names, api.valueFilters) {
+		if f, ok := timesliceFunctions[v]; ok {
+			selects = append(selects, fmt.Sprintf("%s AS %s", f, nrqlString(v)))
+		}
+	}
+	sort.Strings(selects)
+
+	if len(selects) == 0 || len(names) == 0 {
+		return nil, nil
+	}
+
+	channel := make(chan MetricData)
+	metricDatas := make([]MetricData, 0)
+	var errs errorCollector
+
+	go func(ch chan MetricData) {
+		var wg sync.WaitGroup
+
+		for i := 0; i < len(names); i += ChunkSize {
+			var thisList []MetricName
+
+			if i+ChunkSize > len(names) {
+				thisList = names[i:]
+			} else {
+				thisList = names[i : i+ChunkSize]
+			}
+
+			wg.Add(1)
+
+			go func(i int, names []MetricName) (err error) {
+				defer wg.Done()
+				defer errs.add(&err, "app %d metric names %d-%d", appId, i, i+len(names)-1)
+
+				quoted := make([]string, len(names))
+				for i, n := range names {
+					quoted[i] = nrqlString(n.Name)
+				}
+
+				results, err := api.nrql(ctx, fmt.Sprintf(
+					"SELECT %s FROM Metric WHERE appId = %d AND metricTimesliceName IN (%s) FACET metricTimesliceName SINCE %d UNTIL %d LIMIT MAX",
+					strings.Join(selects, ", "), appId, strings.Join(quoted, ", "),
+					from.UnixNano()/int64(time.Millisecond), to.UnixNano()/int64(time.Millisecond)))
+				if err != nil {
+					log.Error("Error requesting metrics: ", err)
+					return err
+				}
+
+				for _, row := range results {
+					name, ok := row["facet"].(string)
+					if !ok {
+						continue
+					}
+
+					values := make(map[string]interface{})
+					for k, v := range row {
+						if _, ok := timesliceFunctions[k]; ok {
+							values[k] = v
+						}
+					}
+
+					ch <- MetricData{
+						Name:       name,
+						Timeslices: []Timeslice{{From: from, To: to, Values: values}},
+					}
+				}
+
+				return nil
+			}(i, thisList)
+		}
+
+		wg.Wait() // wait for all goroutines to finish
+		close(ch)
+	}(channel)
+
+	// receiving
+	for md := range channel {
+		metricDatas = append(metricDatas, md)
+	}
+
+	return metricDatas, errs.err()
+}
+
+// errNoHosts is returned for host breakdowns, which only the REST API has.
+var errNoHosts = errors.New("hosts and instances are not available from NerdGraph")
+
+func (api *GraphQLAPI) GetHosts(ctx context.Context, appID int) ([]Host, error) {
+	return nil, errNoHosts
+}
+
+func (api *GraphQLAPI) GetInstances(ctx context.Context, appID int) ([]Instance, error) {
+	return nil, errNoHosts
+}
+
+func (api *GraphQLAPI) GetHostMetricData(ctx context.Context, appID, hostID int, names []MetricName, from time.Time, to time.Time) ([]MetricData, error) {
+	return nil, errNoHosts
+}
+
+// errNoDeployments is returned for deployments, which only the REST API has.
+var errNoDeployments = errors.New("deployments are not available from NerdGraph")
+
+func (api *GraphQLAPI) GetDeployments(ctx context.Context, appID int, since time.Time) ([]Deployment, error) {
+	return nil, errNoDeployments
+}
+
+// nrql runs an NRQL query against the configured account and returns its result rows.
+func (api *GraphQLAPI) nrql(ctx context.Context, query string) ([]map[string]interface{}, error) {
+	var data struct {
+		Actor struct {
+			Account struct {
+				Nrql struct {
+					Results []map[string]interface{} `json:"results"`
+				} `json:"nrql"`
+			} `json:"account"`
+		} `json:"actor"`
+	}
+
+	err := api.query(ctx, nrqlQuery, map[string]interface{}{
+		"accountId": api.accountID,
+		"nrql":      query,
+	}, &data)
+
+	return data.Actor.Account.Nrql.Results, err
+}
+
+// query posts a GraphQL query and decodes its data into out.
+func (api *GraphQLAPI) query(ctx context.Context, query string, vars map[string]interface{}, out interface{}) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": vars,
+	})
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(api.server.String() + GraphQLPath)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("API-Key", api.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	_, body, err := api.do(ctx, api.client, req)
+	if err != nil {
+		return err
+	}
+
+	var response graphQLResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return err
+	}
+
+	if len(response.Errors) > 0 {
+		messages := make([]string, len(response.Errors))
+		for i, e := range response.Errors {
+			messages[i] = e.Message
+		}
+		return errors.New(strings.Join(messages, "; "))
+	}
+
+	return json.Unmarshal(response.Data, out)
+}
+
+// application converts an entity into the REST v2 application shape.
+func (e apmEntity) application() Application {
+	app := Application{
+		ID:        e.ApplicationID,
+		Name:      e.Name,
+		Health:    alertSeverityHealth[e.AlertSeverity],
+		Reporting: e.Reporting,
+		Language:  e.Language,
+	}
+
+	if s := e.ApmSummary; s != nil {
+		app.AppSummary = map[string]float64{
+			"response_time":  s.ResponseTimeAverage * 1000,
+			"throughput":     s.Throughput,
+			"error_rate":     s.ErrorRate,
+			"apdex_score":    s.ApdexScore,
+			"host_count":     s.HostCount,
+			"instance_count": s.InstanceCount,
+		}
+	}
+
+	if s := e.ApmBrowserSummary; s != nil {
+		app.UsrSummary = map[string]float64{
+			"response_time": s.PageLoadTimeAverage,
+			"throughput":    s.PageLoadThroughput,
+			"apdex_score":   s.ApdexScore,
+		}
+	}
+
+	return app
+}
+
+// nrqlString quotes s as an NRQL string literal.
+func nrqlString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,106 @@
+package newrelic
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrf/newrelic_exporter/config"
+)
+
+func testGraphQLServer(t *testing.T) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path != GraphQLPath || r.Header.Get("API-Key") != testApiKey {
+			w.WriteHeader(403)
+			return
+		}
+
+		var req struct {
+			Query     string
+			Variables map[string]interface{}
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+
+		nrql, _ := req.Variables["nrql"].(string)
+
+		switch {
+
+		case strings.Contains(req.Query, "entitySearch"):
+			w.Write([]byte(`{"data":{"actor":{"entitySearch":{"results":{"nextCursor":null,"entities":[
+				{"applicationId":9045822,"name":"Test/Client/Name","alertSeverity":"NOT_ALERTING",
+				 "apmSummary":{"apdexScore":0.84,"errorRate":0,"hostCount":3,"instanceCount":30,"responseTimeAverage":0.441,"throughput":54.7}}
+			]}}}}}`))
+
+		case strings.Contains(nrql, "uniques(metricTimesliceName"):
+			w.Write([]byte(`{"data":{"actor":{"account":{"nrql":{"results":[
+				{"uniques.metricTimesliceName":["Datastore/statement/JDBC/messages/insert","Datastore/statement/JDBC/messages/update"]}
+			]}}}}}`))
+
+		case strings.Contains(nrql, "FACET metricTimesliceName"):
+			w.Write([]byte(`{"data":{"actor":{"account":{"nrql":{"results":[
+				{"facet":"Datastore/statement/JDBC/messages/insert","call_count":2,"calls_per_minute":2.03}
+			]}}}}}`))
+
+		default:
+			w.Write([]byte(`{"errors":[{"message":"unexpected query"}]}`))
+
+		}
+
+	}))
+}
+
+func TestGraphQLScrape(t *testing.T) {
+
+	ts := testGraphQLServer(t)
+	defer ts.Close()
+
+	api := NewGraphQLAPI(config.Config{Account: config.Account{
+		NRApiKey:        testApiKey,
+		NRApiServer:     ts.URL,
+		NRAccountID:     1,
+		NRTimeout:       testTimeout,
+		NRMetricFilters: []string{"Datastore/statement/JDBC/messages"},
+		NRValueFilters:  []string{"call_count", "calls_per_minute"},
+	}})
+
+	apps, err := api.GetApplications(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(apps) != 1 || apps[0].ID != testApiAppId || apps[0].Health != "green" {
+		t.Fatal("Wrong application list", apps)
+	}
+
+	if apps[0].AppSummary["response_time"] != 441 {
+		t.Fatal("Wrong response time", apps[0].AppSummary["response_time"])
+	}
+
+	names, err := api.GetMetricNames(context.Background(), testApiAppId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != 2 {
+		t.Fatal("Expected 2 metric names, got", len(names))
+	}
+
+	data, err := api.GetMetricData(context.Background(), testApiAppId, names, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != 1 || data[0].Timeslices[0].Values["call_count"].(float64) != 2 {
+		t.Fatal("Wrong metric data", data)
+	}
+
+}
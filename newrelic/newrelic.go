@@ -1,17 +1,19 @@
 package newrelic
 
 import (
-	"crypto/tls"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/antonholmquist/jason"
 	"github.com/mrf/newrelic_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/log"
 	"github.com/tomnomnom/linkheader"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -25,23 +27,114 @@ const UserAgent = "Prometheus-NewRelic-Exporter/" + Version
 // Chunk size of metric requests
 const ChunkSize = 10
 
-var cfg config.Config
+// Client is implemented by every API backend the exporter can scrape.
+type Client interface {
+	prometheus.Collector
+
+	GetApplications(ctx context.Context) ([]Application, error)
+	GetMetricNames(ctx context.Context, appID int) ([]MetricName, error)
+	GetMetricData(ctx context.Context, appID int, names []MetricName, from time.Time, to time.Time) ([]MetricData, error)
+	GetHosts(ctx context.Context, appID int) ([]Host, error)
+	GetInstances(ctx context.Context, appID int) ([]Instance, error)
+	GetHostMetricData(ctx context.Context, appID, hostID int, names []MetricName, from time.Time, to time.Time) ([]MetricData, error)
+	GetDeployments(ctx context.Context, appID int, since time.Time) ([]Deployment, error)
+	QueryNRQL(ctx context.Context, query string) ([]NRQLResult, error)
+	Budget() Budget
+
+	// WithFilters returns a client for the same account that requests the
+	// given metric names and values instead of the configured ones. It
+	// shares limits and counters with the original.
+	WithFilters(metricFilters, valueFilters []string) Client
+
+	// WithConfig returns a client with the settings of cfg, which may select
+	// another backend. It keeps the limits state and counters of this client.
+	// The limits of cfg only take effect with ApplyLimits of the new client.
+	WithConfig(cfg config.Config) (Client, error)
+
+	// ApplyLimits applies the limits of the client's settings to the limits
+	// state it shares with the clients it was derived from.
+	ApplyLimits()
+}
+
+// NewClient returns the backend selected by api.backend. The REST v2 API is
+// used unless "nerdgraph" is configured.
+func NewClient(c config.Config) (Client, error) {
+	return newClient(c, newRetrier(c))
+}
+
+func newClient(c config.Config, r *retrier) (Client, error) {
+	switch c.NRBackend {
+	case "", "rest":
+		return newAPI(c, r)
+	case "nerdgraph":
+		return newGraphQLAPI(c, r)
+	}
+
+	return nil, fmt.Errorf("unknown API backend %q", c.NRBackend)
+}
+
+// reconfigure returns the client of cfg around the limiter and counters of r.
+func reconfigure(r *retrier, cfg config.Config) (Client, error) {
+	return newClient(cfg, r.withRetries(cfg))
+}
 
 type API struct {
+	*retrier
 	server          url.URL
+	insightsServer  url.URL
 	apiKey          string
+	queryKey        string
+	accountID       int
 	service         string
+	apps            *appFilter
+	metricFilters   []string
+	valueFilters    []string
 	Period          int
 	unreportingApps bool
 	client          *http.Client
 }
 
 type Application struct {
+	ID             int
+	Name           string
+	Health         string             `json:"health_status"`
+	Reporting      bool               `json:"reporting"`
+	LastReportedAt time.Time          `json:"last_reported_at"`
+	Language       string             `json:"language"`
+	AppSummary     map[string]float64 `json:"application_summary"`
+	UsrSummary     map[string]float64 `json:"end_user_summary"`
+	// Numbers and booleans such as app_apdex_threshold
+	Settings map[string]interface{} `json:"settings"`
+	Links    struct {
+		AlertPolicy int `json:"alert_policy"`
+	} `json:"links"`
+}
+
+// Host is a server running an application, with the summary of the
+// application on it.
+type Host struct {
 	ID         int
-	Name       string
+	Host       string             `json:"host"`
+	Health     string             `json:"health_status"`
+	AppSummary map[string]float64 `json:"application_summary"`
+}
+
+// Instance is one agent of an application, with the summary of its data.
+type Instance struct {
+	ID         int
+	Host       string             `json:"host"`
+	Port       int                `json:"port"`
 	Health     string             `json:"health_status"`
 	AppSummary map[string]float64 `json:"application_summary"`
-	UsrSummary map[string]float64 `json:"end_user_summary"`
+}
+
+// Deployment is a deployment recorded for an application.
+type Deployment struct {
+	ID          int       `json:"id"`
+	Revision    string    `json:"revision"`
+	User        string    `json:"user"`
+	Description string    `json:"description"`
+	Timestamp   time.Time `json:"timestamp"`
 }
 
 type MetricName struct {
@@ -51,136 +144,225 @@ type MetricName struct {
 
 type MetricData struct {
 	Name       string
-	Timeslices []struct {
-		Values map[string]interface{}
-	}
+	Timeslices []Timeslice
+}
+
+type Timeslice struct {
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Values map[string]interface{}
 }
 
-func NewAPI(c config.Config) *API {
-	cfg = c
+func NewAPI(cfg config.Config) *API {
+	api, err := newAPI(cfg, newRetrier(cfg))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return api
+}
 
+func newAPI(cfg config.Config, r *retrier) (*API, error) {
 	serverURL, err := url.Parse(cfg.NRApiServer)
 	if err != nil {
-		log.Fatal("Could not parse API URL: ", err)
+		return nil, fmt.Errorf("could not parse API URL: %v", err)
 	}
 	if cfg.NRApiKey == "" {
-		log.Fatal("Cannot continue without an API key.")
+		return nil, errors.New("cannot continue without an API key")
 	}
 	if cfg.NRService == "" {
-		log.Fatal("Cannot continue without NewRelic service selected")
+		return nil, errors.New("cannot continue without NewRelic service selected")
 	}
 
-	client := &http.Client{Timeout: cfg.NRTimeout}
+	insightsServer := cfg.NRInsightsServer
+	if insightsServer == "" {
+		insightsServer = config.Regions[config.DefaultRegion].Insights
+	}
+	insightsURL, err := url.Parse(insightsServer)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse Insights API URL: %v", err)
+	}
 
-	if len(cfg.DebugProxyAddress) > 0 {
-		proxyUrl, _ := url.Parse(cfg.DebugProxyAddress)
-		transport := &http.Transport{}
-		transport.Proxy = http.ProxyURL(proxyUrl)
-		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-		client.Transport = transport
+	apps, err := newAppFilter(cfg.NRApps, cfg.NRExcludeApps)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse application filters: %v", err)
 	}
 
-	return &API{
-		server:  *serverURL,
-		apiKey:  cfg.NRApiKey,
-		service: cfg.NRService,
-		client:  client,
-		Period:  cfg.NRPeriod,
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
 	}
+
+	return &API{
+		retrier:        r,
+		server:         *serverURL,
+		insightsServer: *insightsURL,
+		apiKey:         cfg.NRApiKey,
+		queryKey:       cfg.NRQueryKey,
+		accountID:      cfg.NRAccountID,
+		service:        cfg.NRService,
+		apps:           apps,
+		metricFilters:  cfg.NRMetricFilters,
+		valueFilters:   cfg.NRValueFilters,
+		client:         client,
+		Period:         cfg.NRPeriod,
+	}, nil
+}
+
+func (api *API) WithConfig(cfg config.Config) (Client, error) {
+	return reconfigure(api.retrier, cfg)
+}
+
+func (api *API) WithFilters(metricFilters, valueFilters []string) Client {
+	filtered := *api
+	filtered.metricFilters = metricFilters
+	filtered.valueFilters = valueFilters
+	return &filtered
 }
 
-func (api *API) GetApplications() ([]Application, error) {
+func (api *API) GetApplications(ctx context.Context) ([]Application, error) {
 	log.Infof("Requesting application list from %s.", api.server.String())
 
-	body, err := api.req(fmt.Sprintf("/v2/%s.json", api.service), "")
+	var applications []Application
+
+	// Exact IDs and names are filtered by the API, everything else client-side.
+	if ids, names, ok := api.apps.exact(); ok {
+		if len(ids) > 0 {
+			idStrings := make([]string, len(ids))
+			for i, id := range ids {
+				idStrings[i] = strconv.Itoa(id)
+			}
+
+			params := url.Values{}
+			params.Add("filter[ids]", strings.Join(idStrings, ","))
+
+			apps, err := api.getApplications(ctx, params.Encode())
+			if err != nil {
+				return nil, err
+			}
+			applications = append(applications, apps...)
+		}
+
+		for _, name := range names {
+			params := url.Values{}
+			params.Add("filter[name]", name)
+
+			apps, err := api.getApplications(ctx, params.Encode())
+			if err != nil {
+				return nil, err
+			}
+			applications = append(applications, apps...)
+		}
+	} else {
+		var err error
+
+		applications, err = api.getApplications(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	applications = api.apps.Filter(applications)
+
+	log.Debugf("Found %v applications: %v", len(applications), applications)
+
+	return applications, nil
+}
+
+func (api *API) getApplications(ctx context.Context, params string) ([]Application, error) {
+	pages, err := api.req(ctx, fmt.Sprintf("/v2/%s.json", api.service), params)
 	if err != nil {
 		log.Error("Error getting application list: ", err)
 		return nil, err
 	}
 
-	v, err := jason.NewObjectFromBytes(body)
-
 	var applications []Application
 
-	if err == nil {
-		appsArray, err := v.GetObjectArray("applications")
+	for _, body := range pages {
+		v, err := jason.NewObjectFromBytes(body)
+		if err != nil {
+			return applications, err
+		}
 
-		applications = make([]Application, len(appsArray))
-		if err == nil {
-			for i, a := range appsArray {
-				application := new(Application)
-				aBytes, _ := a.Marshal()
-				json.Unmarshal(aBytes, application)
-				applications[i] = *application
-			}
+		appsArray, err := v.GetObjectArray("applications")
+		if err != nil {
+			return applications, err
 		}
 
-		log.Debugf("Found %v applications: %v", len(applications), applications)
+		for _, a := range appsArray {
+			application := new(Application)
+			aBytes, _ := a.Marshal()
+			json.Unmarshal(aBytes, application)
+			applications = append(applications, *application)
+		}
 	}
 
-	return applications, err
+	return applications, nil
 }
 
-func (api *API) GetMetricNames(appID int) ([]MetricName, error) {
-	log.Infof("Requesting metrics names for application id %d with %v filters", appID, len(cfg.NRMetricFilters))
+func (api *API) GetMetricNames(ctx context.Context, appID int) ([]MetricName, error) {
+	log.Infof("Requesting metrics names for application id %d with %v filters", appID, len(api.metricFilters))
 	path := fmt.Sprintf("/v2/%s/%s/metrics.json", api.service, strconv.Itoa(appID))
 
 	channel := make(chan MetricName)
 	metricNames := make([]MetricName, 0)
+	var errs errorCollector
 
 	// We will only make filtered requests for metric names. Otherwise there are too many of them (tens of thousands)
 	go func(ch chan MetricName) {
 		var filter string
 		var wg sync.WaitGroup
 
-		for _, filter = range cfg.NRMetricFilters {
+		for _, filter = range api.metricFilters {
 			log.Debugf("Scraping filter %v for app %v", filter, appID)
 
 			wg.Add(1)
 
-			go func(filter string) error {
+			go func(filter string) (err error) {
 				defer wg.Done()
+				defer errs.add(&err, "app %d filter %q", appID, filter)
 
 				params := url.Values{}
 				params.Add("name", filter)
 
-				body, err := api.req(path, params.Encode())
+				pages, err := api.req(ctx, path, params.Encode())
 				if err != nil {
 					log.Error("Error getting metric names:", err)
 					return err
 				}
 
-				v, err := jason.NewObjectFromBytes(body)
-				if err != nil {
-					log.Error("Error parsing metric names from JSON:", err)
-					return err
-				}
-
-				metricsArray, err := v.GetObjectArray("metrics")
-				if err != nil {
-					log.Error("Error parsing metric names from JSON:", err)
-					return err
-				}
-
-				for _, mn := range metricsArray {
-					metric := new(MetricName)
-
-					mnBytes, err := mn.Marshal()
+				for _, body := range pages {
+					v, err := jason.NewObjectFromBytes(body)
 					if err != nil {
-						log.Error("Error marshalling metric to JSON object:", err)
+						log.Error("Error parsing metric names from JSON:", err)
 						return err
 					}
 
-					err = json.Unmarshal(mnBytes, metric)
+					metricsArray, err := v.GetObjectArray("metrics")
 					if err != nil {
-						log.Error("Error unmarshalling metric from JSON object:", err)
+						log.Error("Error parsing metric names from JSON:", err)
 						return err
 					}
 
-					ch <- *metric
-				}
+					for _, mn := range metricsArray {
+						metric := new(MetricName)
+
+						mnBytes, err := mn.Marshal()
+						if err != nil {
+							log.Error("Error marshalling metric to JSON object:", err)
+							return err
+						}
 
-				log.Debugf("Found %v possible metric names for app %v and filter %v", len(metricsArray), appID, filter)
+						err = json.Unmarshal(mnBytes, metric)
+						if err != nil {
+							log.Error("Error unmarshalling metric from JSON object:", err)
+							return err
+						}
+
+						ch <- *metric
+					}
+
+					log.Debugf("Found %v possible metric names for app %v and filter %v", len(metricsArray), appID, filter)
+				}
 
 				return nil
 			}(filter)
@@ -195,31 +377,110 @@ func (api *API) GetMetricNames(appID int) ([]MetricName, error) {
 		metricNames = append(metricNames, mn)
 	}
 
-	return metricNames, nil
+	return metricNames, errs.err()
 }
 
-func (api *API) GetMetricData(appId int, names []MetricName, from time.Time, to time.Time) ([]MetricData, error) {
+func (api *API) GetMetricData(ctx context.Context, appId int, names []MetricName, from time.Time, to time.Time) ([]MetricData, error) {
 	path := fmt.Sprintf("/v2/%s/%s/metrics/data.json", api.service, strconv.Itoa(appId))
 
-	var valueNamesList []string
+	return api.getMetricData(ctx, path, names, from, to)
+}
 
-	// If Values Filter is set in config we will use it. Otherwise - gather all possible value names from metric names
-	if len(cfg.NRValueFilters) == 0 {
-		valueNamesSet := make(map[string]struct{})
+// GetHosts returns the hosts an application runs on.
+func (api *API) GetHosts(ctx context.Context, appID int) ([]Host, error) {
+	var hosts []Host
 
-		for _, name := range names {
-			for _, v := range name.ValueNames {
-				valueNamesSet[v] = struct{}{}
-			}
+	err := api.list(ctx, fmt.Sprintf("/v2/%s/%d/hosts.json", api.service, appID), func(body []byte) error {
+		var page struct {
+			Hosts []Host `json:"application_hosts"`
 		}
+		err := json.Unmarshal(body, &page)
+		hosts = append(hosts, page.Hosts...)
+		return err
+	})
 
-		for k := range valueNamesSet {
-			valueNamesList = append(valueNamesList, k)
+	return hosts, err
+}
+
+// GetInstances returns the agents of an application.
+func (api *API) GetInstances(ctx context.Context, appID int) ([]Instance, error) {
+	var instances []Instance
+
+	err := api.list(ctx, fmt.Sprintf("/v2/%s/%d/instances.json", api.service, appID), func(body []byte) error {
+		var page struct {
+			Instances []Instance `json:"application_instances"`
 		}
-	} else {
-		valueNamesList = append(valueNamesList, cfg.NRValueFilters...)
+		err := json.Unmarshal(body, &page)
+		instances = append(instances, page.Instances...)
+		return err
+	})
+
+	return instances, err
+}
+
+// GetHostMetricData returns the metric data of an application on one host.
+func (api *API) GetHostMetricData(ctx context.Context, appID, hostID int, names []MetricName, from time.Time, to time.Time) ([]MetricData, error) {
+	path := fmt.Sprintf("/v2/%s/%d/hosts/%d/metrics/data.json", api.service, appID, hostID)
+
+	return api.getMetricData(ctx, path, names, from, to)
+}
+
+// GetDeployments returns the deployments of an application, newest first.
+// Pages are requested until one reaches back to since.
+func (api *API) GetDeployments(ctx context.Context, appID int, since time.Time) ([]Deployment, error) {
+	path := fmt.Sprintf("/v2/%s/%d/deployments.json", api.service, appID)
+
+	var deployments []Deployment
+
+	more := func(body []byte) bool {
+		var page struct {
+			Deployments []Deployment `json:"deployments"`
+		}
+		if json.Unmarshal(body, &page) != nil || len(page.Deployments) == 0 {
+			return false
+		}
+		return page.Deployments[len(page.Deployments)-1].Timestamp.After(since)
+	}
+
+	pages, err := api.reqPages(ctx, path, "", more)
+	if err != nil {
+		return nil, err
 	}
 
+	for _, body := range pages {
+		var page struct {
+			Deployments []Deployment `json:"deployments"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", path, err)
+		}
+		deployments = append(deployments, page.Deployments...)
+	}
+
+	return deployments, nil
+}
+
+// list requests every page of path and passes their bodies to parse.
+func (api *API) list(ctx context.Context, path string, parse func(body []byte) error) error {
+	pages, err := api.req(ctx, path, "")
+	if err != nil {
+		return err
+	}
+
+	for _, body := range pages {
+		if err := parse(body); err != nil {
+			return fmt.Errorf("parsing %s: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+// getMetricData requests the values of the metric names from path, the
+// metric data of an application or of one of its hosts.
+func (api *API) getMetricData(ctx context.Context, path string, names []MetricName, from time.Time, to time.Time) ([]MetricData, error) {
+	valueNamesList := valueNames(names, api.valueFilters)
+
 	// Because the Go client does not yet support 100-continue
 	// ( see issue #3665 ),
 	// we have to process this in chunks, to ensure the response
@@ -227,6 +488,7 @@ func (api *API) GetMetricData(appId int, names []MetricName, from time.Time, to
 
 	channel := make(chan MetricData)
 	metricDatas := make([]MetricData, 0)
+	var errs errorCollector
 
 	go func(ch chan MetricData) {
 		var wg sync.WaitGroup
@@ -242,8 +504,9 @@ func (api *API) GetMetricData(appId int, names []MetricName, from time.Time, to
 
 			wg.Add(1)
 
-			go func(names []MetricName) error {
+			go func(i int, names []MetricName) (err error) {
 				defer wg.Done()
+				defer errs.add(&err, "%s metric names %d-%d", path, i, i+len(names)-1)
 
 				params := url.Values{}
 
@@ -261,44 +524,45 @@ func (api *API) GetMetricData(appId int, names []MetricName, from time.Time, to
 				params.Add("from", from.Format(time.RFC3339))
 				params.Add("to", to.Format(time.RFC3339))
 
-				body, err := api.req(path, params.Encode())
+				pages, err := api.req(ctx, path, params.Encode())
 				if err != nil {
 					log.Error("Error requesting metrics: ", err)
 					return err
 				}
 
-				v, err := jason.NewObjectFromBytes(body)
-				if err != nil {
-					log.Error("Error parsing metric names from JSON:", err)
-					return err
-				}
-
-				metricsData, err := v.GetObject("metric_data")
-				metricsArray, err := metricsData.GetObjectArray("metrics")
-				if err != nil {
-					log.Error("Error parsing metric names from JSON:", err)
-					return err
-				}
-
-				for _, md := range metricsArray {
-					metric := new(MetricData)
-
-					mdBytes, err := md.Marshal()
+				for _, body := range pages {
+					v, err := jason.NewObjectFromBytes(body)
 					if err != nil {
-						log.Error("Error marshalling metric to JSON object:", err)
+						log.Error("Error parsing metric names from JSON:", err)
 						return err
 					}
 
-					err = json.Unmarshal(mdBytes, metric)
+					metricsArray, err := v.GetObjectArray("metric_data", "metrics")
 					if err != nil {
-						log.Error("Error unmarshalling metric from JSON object:", err)
+						log.Error("Error parsing metric names from JSON:", err)
 						return err
 					}
-					ch <- *metric
+
+					for _, md := range metricsArray {
+						metric := new(MetricData)
+
+						mdBytes, err := md.Marshal()
+						if err != nil {
+							log.Error("Error marshalling metric to JSON object:", err)
+							return err
+						}
+
+						err = json.Unmarshal(mdBytes, metric)
+						if err != nil {
+							log.Error("Error unmarshalling metric from JSON object:", err)
+							return err
+						}
+						ch <- *metric
+					}
 				}
 
 				return nil
-			}(thisList)
+			}(i, thisList)
 		}
 
 		wg.Wait() // wait for all goroutines to finish
@@ -313,10 +577,41 @@ func (api *API) GetMetricData(appId int, names []MetricName, from time.Time, to
 		datasCollected++
 	}
 
-	return metricDatas, nil
+	return metricDatas, errs.err()
 }
 
-func (api *API) req(path string, params string) ([]byte, error) {
+// valueNames returns the value names to request for the given metric names.
+// If Values Filter is set in config we will use it. Otherwise - gather all possible value names from metric names
+func valueNames(names []MetricName, valueFilters []string) []string {
+	var valueNamesList []string
+
+	if len(valueFilters) == 0 {
+		valueNamesSet := make(map[string]struct{})
+
+		for _, name := range names {
+			for _, v := range name.ValueNames {
+				valueNamesSet[v] = struct{}{}
+			}
+		}
+
+		for k := range valueNamesSet {
+			valueNamesList = append(valueNamesList, k)
+		}
+	} else {
+		valueNamesList = append(valueNamesList, valueFilters...)
+	}
+
+	return valueNamesList
+}
+
+func (api *API) req(ctx context.Context, path string, params string) ([][]byte, error) {
+	return api.reqPages(ctx, path, params, nil)
+}
+
+// reqPages requests path like req, but only follows to the next page while
+// more returns true for the body of the last one. A nil more follows all
+// pages.
+func (api *API) reqPages(ctx context.Context, path string, params string, more func(body []byte) bool) ([][]byte, error) {
 	u, err := url.Parse(api.server.String() + path)
 	if err != nil {
 		return nil, err
@@ -334,32 +629,19 @@ func (api *API) req(path string, params string) ([]byte, error) {
 		},
 	}
 
-	var data []byte
-
-	return api.httpget(req, data)
+	return api.httpget(ctx, req, more, nil)
 }
 
-func (api *API) httpget(req *http.Request, in []byte) (out []byte, err error) {
-	resp, err := api.client.Do(req)
+// httpget performs the request and follows the "next" relation of the Link
+// header while more, if set, returns true for the last body, returning the
+// body of every page in order.
+func (api *API) httpget(ctx context.Context, req *http.Request, more func(body []byte) bool, in [][]byte) (out [][]byte, err error) {
+	resp, body, err := api.do(ctx, api.client, req)
 	if err != nil {
 		return
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return
-	}
-
-	if resp.StatusCode == 429 {
-		log.Info("API Limit Exceeded, New Relic Returning 429 see: https://docs.newrelic.com/docs/apis/rest-api-v2/requirements/api-overload-protection-handling-429-errors")
-		overload_header := resp.Header["Newrelic-Overloadprotection-Reset"][0]
-		overload_time, _ := strconv.ParseInt(overload_header, 10, 64)
-		log.Info("Overload protection resets at: ", time.Unix(overload_time, 0))
-		return
-	}
-
-	resp.Body.Close()
-	out = append(in, body...)
+	out = append(in, body)
 
 	// Read the link header to see if we need to read more pages.
 	links := linkheader.Parse(resp.Header.Get("Link"))
@@ -369,30 +651,35 @@ func (api *API) httpget(req *http.Request, in []byte) (out []byte, err error) {
 		u, err := url.Parse(relLast[0].URL)
 		if err != nil {
 			log.Errorf("Error parsing 'last' relation link. %v", err)
+		} else {
+			log.Debugf("Found %v pages for %s", u.Query().Get("page"), req.URL)
 		}
-		log.Debugf("Found %v pages for %s", u.Query().Get("page"), req.URL)
 	}
 
 	relNext := links.FilterByRel("next")
-	if len(relNext) > 0 {
+	if len(relNext) > 0 && (more == nil || more(body)) {
 		u := new(url.URL)
 
 		u, err = url.Parse(relNext[0].URL)
 		if err != nil {
 			return
 		}
-		cursor := u.Query().Get("cursor")
 
-		if cursor != "" {
-			query := req.URL.Query()
+		query := req.URL.Query()
 
+		// Newer endpoints paginate with a cursor, older ones with a page number.
+		if cursor := u.Query().Get("cursor"); cursor != "" {
 			query.Set("cursor", cursor)
 			query.Del("page")
+		} else if page := u.Query().Get("page"); page != "" {
+			query.Set("page", page)
+		} else {
+			return
+		}
 
-			req.URL.RawQuery = query.Encode()
+		req.URL.RawQuery = query.Encode()
 
-			return api.httpget(req, out)
-		}
+		return api.httpget(ctx, req, more, out)
 	}
 
 	return
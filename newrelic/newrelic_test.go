@@ -0,0 +1,304 @@
+package newrelic
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrf/newrelic_exporter/config"
+)
+
+var testApiKey string = "205071e37e95bdaa327c62ccd3201da9289ccd17"
+var testApiAppId int = 9045822
+var testTimeout time.Duration = 5 * time.Second
+
+func testAPI(url string) *API {
+	api := NewAPI(config.Config{Account: config.Account{
+		NRApiKey:        testApiKey,
+		NRApiServer:     url,
+		NRService:       "applications",
+		NRTimeout:       testTimeout,
+		NRMetricFilters: []string{"Datastore/statement/JDBC/messages"},
+	}})
+	api.client = &http.Client{
+		Timeout: testTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		},
+	}
+
+	return api
+}
+
+func TestAppListGet(t *testing.T) {
+
+	ts, err := testServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ts.Close()
+
+	api := testAPI(ts.URL)
+
+	apps, err := api.GetApplications(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(apps) != 1 {
+		t.Fatal("Expected 1 application, got", len(apps))
+	}
+
+	a := apps[0]
+
+	switch {
+
+	case a.ID != testApiAppId:
+		t.Fatal("Wrong ID")
+
+	case a.Health != "green":
+		t.Fatal("Wrong health status")
+
+	case a.Name != "Test/Client/Name":
+		t.Fatal("Wrong name")
+
+	case a.AppSummary["throughput"] != 54.7:
+		t.Fatal("Wrong throughput")
+
+	case a.AppSummary["host_count"] != 3:
+		t.Fatal("Wrong host count")
+
+	case a.UsrSummary["response_time"] != 4.61:
+		t.Fatal("Wrong response time")
+
+	}
+
+}
+
+func TestMetricNamesGet(t *testing.T) {
+
+	ts, err := testServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ts.Close()
+
+	api := testAPI(ts.URL)
+
+	names, err := api.GetMetricNames(context.Background(), testApiAppId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != 2 {
+		t.Fatal("Expected 2 name sets, got", len(names))
+	}
+
+	if len(names[0].ValueNames) != 10 {
+		t.Fatal("Expected 10 metric names")
+	}
+
+	if names[0].Name != "Datastore/statement/JDBC/messages/insert" {
+		t.Fatal("Wrong application name")
+	}
+	if names[1].Name != "Datastore/statement/JDBC/messages/update" {
+		t.Fatal("Wrong application name")
+	}
+
+}
+
+func TestMetricValuesGet(t *testing.T) {
+
+	ts, err := testServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ts.Close()
+
+	api := testAPI(ts.URL)
+
+	names, err := api.GetMetricNames(context.Background(), testApiAppId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := api.GetMetricData(context.Background(), testApiAppId, names, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != 1 {
+		t.Fatal("Expected 1 metric sets")
+	}
+
+	if len(data[0].Timeslices) != 1 {
+		t.Fatal("Expected 1 timeslice")
+	}
+
+	appData := data[0].Timeslices[0]
+
+	if len(appData.Values) != 10 {
+		t.Fatal("Expected 10 data points")
+	}
+
+	if appData.Values["call_count"].(float64) != 2 {
+		t.Fatal("Wrong call_count value")
+	}
+
+	if appData.Values["calls_per_minute"].(float64) != 2.03 {
+		t.Fatal("Wrong calls_per_minute value")
+	}
+
+}
+
+func TestDeploymentsGet(t *testing.T) {
+
+	ts, err := testServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ts.Close()
+
+	api := testAPI(ts.URL)
+
+	deployments, err := api.GetDeployments(context.Background(), testApiAppId, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deployments) != 3 {
+		t.Fatal("Expected the deployments of both pages, got", len(deployments))
+	}
+
+	if deployments[0].Revision != "a1b2c3d" || deployments[0].User != "deploy-bot" || !deployments[0].Timestamp.Equal(time.Date(2015, 6, 8, 14, 30, 0, 0, time.UTC)) {
+		t.Fatal("Wrong latest deployment", deployments[0])
+	}
+
+	// The first page reaches back before June 6th, the second is not needed
+	deployments, err = api.GetDeployments(context.Background(), testApiAppId, time.Date(2015, 6, 6, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deployments) != 2 {
+		t.Fatal("Expected the deployments of the first page, got", len(deployments))
+	}
+
+}
+
+func testServer() (ts *httptest.Server, err error) {
+
+	ts = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Header.Get("X-Api-Key") != testApiKey {
+			w.WriteHeader(403)
+		}
+
+		var body []byte
+		var sourceFile string
+
+		firstLink := fmt.Sprintf(
+			"<%s%s?page=%d>; rel=%s, <%s%s?page=%d>; rel=%s",
+			ts.URL, r.URL.Path, 2, `"next"`,
+			ts.URL, r.URL.Path, 2, `"last"`)
+
+		secondLink := fmt.Sprintf(
+			"<%s%s?page=%d>; rel=%s, <%s%s?page=%d>; rel=%s",
+			ts.URL, r.URL.Path, 1, `"first"`,
+			ts.URL, r.URL.Path, 1, `"prev"`)
+
+		switch r.URL.Path {
+
+		case "/v2/applications.json":
+			sourceFile = "../_testing/application_list.json"
+
+		case "/v2/applications/9045822/metrics.json":
+			if r.URL.Query().Get("page") == "2" {
+				sourceFile = ("../_testing/metric_names_2.json")
+				w.Header().Set("Link", secondLink)
+			} else {
+				sourceFile = ("../_testing/metric_names.json")
+				w.Header().Set("Link", firstLink)
+			}
+
+		case "/v2/applications/9045822/metrics/data.json":
+			sourceFile = ("../_testing/metric_data.json")
+
+		case "/v2/applications/9045822/deployments.json":
+			if r.URL.Query().Get("page") == "2" {
+				sourceFile = ("../_testing/deployments_2.json")
+				w.Header().Set("Link", secondLink)
+			} else {
+				sourceFile = ("../_testing/deployments.json")
+				w.Header().Set("Link", firstLink)
+			}
+
+		default:
+			w.WriteHeader(404)
+			return
+
+		}
+
+		body, err = ioutil.ReadFile(sourceFile)
+		if err != nil {
+			return
+		}
+
+		w.WriteHeader(200)
+		w.Write(body)
+
+	}))
+
+	return
+}
+
+func TestMetricNamesPartialFailure(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("name") == "broken" {
+			w.WriteHeader(500)
+			return
+		}
+
+		body, _ := ioutil.ReadFile("../_testing/metric_names.json")
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	api := NewAPI(config.Config{Account: config.Account{
+		NRApiKey:        testApiKey,
+		NRApiServer:     ts.URL,
+		NRService:       "applications",
+		NRTimeout:       testTimeout,
+		NRMaxRetries:    -1,
+		NRMetricFilters: []string{"Datastore", "broken"},
+	}})
+
+	names, err := api.GetMetricNames(context.Background(), testApiAppId)
+
+	if len(names) != 1 {
+		t.Fatal("Expected the names of the working filter, got", names)
+	}
+
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 1 {
+		t.Fatal("Expected one filter error, got", err)
+	}
+
+	if !strings.Contains(errs[0].Error(), `filter "broken"`) {
+		t.Fatal("Error should name the filter:", errs[0])
+	}
+
+}
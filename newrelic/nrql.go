@@ -0,0 +1,219 @@
+package newrelic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// NRQLResult is one row of an NRQL query: the facet values, in FACET order,
+// and every numeric result column. For TIMESERIES queries only the latest
+// bucket of each facet is kept.
+type NRQLResult struct {
+	Facets []string
+	Values map[string]float64
+}
+
+type insightsResults struct {
+	Results    []map[string]interface{} `json:"results"`
+	TimeSeries []struct {
+		Results []map[string]interface{} `json:"results"`
+	} `json:"timeSeries"`
+}
+
+type insightsResponse struct {
+	insightsResults
+	Facets []struct {
+		Name interface{} `json:"name"`
+		insightsResults
+	} `json:"facets"`
+	Metadata interface{} `json:"metadata"`
+}
+
+func (api *GraphQLAPI) QueryNRQL(ctx context.Context, query string) ([]NRQLResult, error) {
+	rows, err := api.nrql(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []NRQLResult
+
+	// TIMESERIES rows come oldest first, so later rows replace earlier ones.
+	index := make(map[string]int)
+
+	for _, row := range rows {
+		result := NRQLResult{
+			Facets: facetValues(row["facet"]),
+			Values: make(map[string]float64),
+		}
+
+		for k, v := range row {
+			switch k {
+			case "facet", "beginTimeSeconds", "endTimeSeconds":
+				continue
+			}
+			flattenValue(k, v, result.Values)
+		}
+
+		key := strings.Join(result.Facets, "\x00")
+		if i, ok := index[key]; ok {
+			results[i] = result
+			continue
+		}
+
+		index[key] = len(results)
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// QueryNRQL runs the query through the Insights query API, which needs
+// api.account-id and an Insights query key in api.query-key.
+func (api *API) QueryNRQL(ctx context.Context, query string) ([]NRQLResult, error) {
+	if api.accountID == 0 || api.queryKey == "" {
+		return nil, errors.New("NRQL queries with the rest backend need api.account-id and api.query-key")
+	}
+
+	u := api.insightsServer
+	u.Path = fmt.Sprintf("/v1/accounts/%d/query", api.accountID)
+	u.RawQuery = url.Values{"nrql": {query}}.Encode()
+
+	req := &http.Request{
+		Method: "GET",
+		URL:    &u,
+		Header: http.Header{
+			"User-Agent":  {UserAgent},
+			"Accept":      {"application/json"},
+			"X-Query-Key": {api.queryKey},
+		},
+	}
+
+	pages, err := api.httpget(ctx, req, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, errors.New("empty response from Insights")
+	}
+
+	var response insightsResponse
+	err = json.Unmarshal(pages[0], &response)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := insightsColumns(response.Metadata)
+
+	if len(response.Facets) == 0 {
+		return []NRQLResult{response.result(nil, columns)}, nil
+	}
+
+	results := make([]NRQLResult, 0, len(response.Facets))
+	for _, facet := range response.Facets {
+		results = append(results, facet.result(facetValues(facet.Name), columns))
+	}
+
+	return results, nil
+}
+
+// result converts one Insights result set, or its latest TIMESERIES bucket.
+func (r insightsResults) result(facets []string, columns []string) NRQLResult {
+	rows := r.Results
+	if len(r.TimeSeries) > 0 {
+		rows = r.TimeSeries[len(r.TimeSeries)-1].Results
+	}
+
+	result := NRQLResult{
+		Facets: facets,
+		Values: make(map[string]float64),
+	}
+
+	// Each row holds a single value keyed by function name, in SELECT order.
+	for i, row := range rows {
+		for k, v := range row {
+			name := k
+			if i < len(columns) {
+				name = columns[i]
+			}
+			flattenValue(name, v, result.Values)
+		}
+	}
+
+	return result
+}
+
+// insightsColumns names the SELECT items listed in the response metadata the
+// way NerdGraph names its result columns: the alias, or function.attribute.
+func insightsColumns(metadata interface{}) []string {
+	switch m := metadata.(type) {
+
+	case map[string]interface{}:
+		for _, key := range []string{"contents", "timeSeries"} {
+			if columns := insightsColumns(m[key]); columns != nil {
+				return columns
+			}
+		}
+
+	case []interface{}:
+		var columns []string
+
+		for _, c := range m {
+			content, ok := c.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+
+			alias, _ := content["alias"].(string)
+			function, _ := content["function"].(string)
+			attribute, _ := content["attribute"].(string)
+
+			switch {
+			case alias != "":
+				columns = append(columns, alias)
+			case attribute != "" && attribute != "*":
+				columns = append(columns, function+"."+attribute)
+			default:
+				columns = append(columns, function)
+			}
+		}
+
+		return columns
+
+	}
+
+	return nil
+}
+
+// facetValues returns the facet value(s) of a result as strings.
+func facetValues(facet interface{}) []string {
+	switch f := facet.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		values := make([]string, len(f))
+		for i, v := range f {
+			values[i] = fmt.Sprint(v)
+		}
+		return values
+	default:
+		return []string{fmt.Sprint(f)}
+	}
+}
+
+// flattenValue stores numeric values, descending into nested results such as
+// percentiles ("percentile.duration.95").
+func flattenValue(name string, value interface{}, values map[string]float64) {
+	switch v := value.(type) {
+	case float64:
+		values[name] = v
+	case map[string]interface{}:
+		for k, nested := range v {
+			flattenValue(name+"."+k, nested, values)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package newrelic
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mrf/newrelic_exporter/config"
+)
+
+func TestGraphQLQueryNRQL(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"actor":{"account":{"nrql":{"results":[
+			{"beginTimeSeconds":0,"endTimeSeconds":60,"facet":"app1","appName":"app1","average.duration":1},
+			{"beginTimeSeconds":0,"endTimeSeconds":60,"facet":"app2","appName":"app2","average.duration":2},
+			{"beginTimeSeconds":60,"endTimeSeconds":120,"facet":"app1","appName":"app1","average.duration":3}
+		]}}}}}`))
+	}))
+	defer ts.Close()
+
+	api := NewGraphQLAPI(config.Config{Account: config.Account{
+		NRApiKey:    testApiKey,
+		NRApiServer: ts.URL,
+		NRAccountID: 1,
+		NRTimeout:   testTimeout,
+	}})
+
+	results, err := api.QueryNRQL(context.Background(), "SELECT average(duration) FROM Transaction FACET appName TIMESERIES")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatal("Expected 2 facets, got", len(results))
+	}
+
+	if results[0].Facets[0] != "app1" || results[0].Values["average.duration"] != 3 {
+		t.Fatal("Expected the latest bucket of app1, got", results[0])
+	}
+
+}
+
+func TestInsightsQueryNRQL(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/accounts/1/query" || r.Header.Get("X-Query-Key") != "query-key" {
+			w.WriteHeader(403)
+			return
+		}
+
+		w.Write([]byte(`{
+			"facets":[
+				{"name":"app1","results":[{"average":1.5},{"count":10}]},
+				{"name":"app2","results":[{"average":2.5},{"count":20}]}
+			],
+			"metadata":{"facet":"appName","contents":{"messages":[],"contents":[
+				{"function":"average","attribute":"duration","simple":true},
+				{"function":"count","attribute":"*","alias":"calls"}
+			]}}
+		}`))
+	}))
+	defer ts.Close()
+
+	api := NewAPI(config.Config{Account: config.Account{
+		NRApiKey:         testApiKey,
+		NRApiServer:      ts.URL,
+		NRInsightsServer: ts.URL,
+		NRQueryKey:       "query-key",
+		NRAccountID:      1,
+		NRService:        "applications",
+		NRTimeout:        testTimeout,
+	}})
+
+	results, err := api.QueryNRQL(context.Background(), "SELECT average(duration), count(*) AS calls FROM Transaction FACET appName")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatal("Expected 2 facets, got", len(results))
+	}
+
+	if results[1].Facets[0] != "app2" || results[1].Values["average.duration"] != 2.5 || results[1].Values["calls"] != 20 {
+		t.Fatal("Wrong result", results[1])
+	}
+
+}
@@ -0,0 +1,203 @@
+package newrelic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mrf/newrelic_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+// Retry defaults, used when api.max-retries and api.retry-backoff are unset
+const (
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = time.Second
+)
+
+// Upper bound of the delay between two retries, before jitter
+const MaxRetryBackoff = 30 * time.Second
+
+// retrier sends API requests within the limits, retrying network errors and
+// 5xx responses with exponential backoff and jitter. 429 responses are retried
+// once the overload protection resets. No retry is scheduled past the context
+// deadline.
+type retrier struct {
+	*limiter
+	// Settings whose limits ApplyLimits applies
+	cfg        config.Config
+	maxRetries int
+	backoff    time.Duration
+	retries    *prometheus.CounterVec
+	throttled  prometheus.Counter
+}
+
+func newRetrier(cfg config.Config) *retrier {
+	r := &retrier{
+		limiter: newLimiter(cfg),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "newrelic_exporter_api_retries_total",
+			Help: "API requests retried, by reason.",
+		}, []string{"reason"}),
+		throttled: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "newrelic_exporter_api_throttled_total",
+			Help: "API responses rejected by overload protection (HTTP 429).",
+		}),
+	}
+
+	return r.withRetries(cfg)
+}
+
+// withRetries returns a retrier with the retry settings of cfg that shares
+// the limiter and counters of r.
+func (r *retrier) withRetries(cfg config.Config) *retrier {
+	r = &retrier{
+		limiter:    r.limiter,
+		cfg:        cfg,
+		maxRetries: cfg.NRMaxRetries,
+		backoff:    cfg.NRRetryBackoff,
+		retries:    r.retries,
+		throttled:  r.throttled,
+	}
+
+	switch {
+	case r.maxRetries == 0:
+		r.maxRetries = DefaultMaxRetries
+	case r.maxRetries < 0:
+		r.maxRetries = 0
+	}
+	if r.backoff <= 0 {
+		r.backoff = DefaultRetryBackoff
+	}
+
+	return r
+}
+
+func (r *retrier) ApplyLimits() {
+	r.limiter.configure(r.cfg)
+}
+
+func (r *retrier) Describe(ch chan<- *prometheus.Desc) {
+	r.limiter.Describe(ch)
+	r.retries.Describe(ch)
+	ch <- r.throttled.Desc()
+}
+
+func (r *retrier) Collect(ch chan<- prometheus.Metric) {
+	r.limiter.Collect(ch)
+	r.retries.Collect(ch)
+	ch <- r.throttled
+}
+
+// do sends req until it succeeds or retries are exhausted, and returns the
+// last response and its body. Responses with status 400 and above are errors.
+func (r *retrier) do(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		resp, body, err := r.send(ctx, client, req)
+
+		var reason string
+		var wait time.Duration
+
+		switch {
+
+		case err != nil:
+			if ctx.Err() != nil || errors.Is(err, ErrBudgetExhausted) {
+				return nil, nil, err
+			}
+			reason = "network_error"
+			wait = r.delay(attempt)
+
+		case resp.StatusCode == http.StatusTooManyRequests:
+			log.Info("API Limit Exceeded, New Relic Returning 429 see: https://docs.newrelic.com/docs/apis/rest-api-v2/requirements/api-overload-protection-handling-429-errors")
+			r.throttled.Inc()
+			reason = "throttled"
+			err = fmt.Errorf("%s returned %s", req.URL.Path, resp.Status)
+			wait = r.delay(attempt)
+
+			reset, perr := strconv.ParseInt(resp.Header.Get("Newrelic-Overloadprotection-Reset"), 10, 64)
+			if perr == nil {
+				log.Info("Overload protection resets at: ", time.Unix(reset, 0))
+				wait = time.Until(time.Unix(reset, 0))
+			}
+
+		case resp.StatusCode >= 500:
+			reason = "server_error"
+			err = fmt.Errorf("%s returned %s", req.URL.Path, resp.Status)
+			wait = r.delay(attempt)
+
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			// Keys of another region are rejected too
+			return resp, body, fmt.Errorf("%s returned %s, check that the key is valid for %s (api.region)", req.URL.Path, resp.Status, req.URL.Host)
+
+		case resp.StatusCode >= 400:
+			return resp, body, fmt.Errorf("%s returned %s", req.URL.Path, resp.Status)
+
+		default:
+			return resp, body, nil
+
+		}
+
+		if attempt >= r.maxRetries {
+			return resp, body, err
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return resp, body, fmt.Errorf("%v, not retrying past the scrape deadline", err)
+		}
+
+		log.Infof("Retrying %s in %v: %v", req.URL.Path, wait, err)
+		r.retries.WithLabelValues(reason).Inc()
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (r *retrier) send(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, []byte, error) {
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	req = req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Body = body
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp, body, nil
+}
+
+// delay returns the exponential backoff of an attempt with jitter of up to half.
+func (r *retrier) delay(attempt int) time.Duration {
+	d := MaxRetryBackoff
+	if attempt < 16 && r.backoff<<uint(attempt) < MaxRetryBackoff {
+		d = r.backoff << uint(attempt)
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,88 @@
+package newrelic
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mrf/newrelic_exporter/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRetry(t *testing.T) {
+
+	var requests int
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		switch requests {
+		case 1:
+			w.WriteHeader(503)
+		case 2:
+			w.Header().Set("Newrelic-Overloadprotection-Reset", strconv.FormatInt(time.Now().Unix(), 10))
+			w.WriteHeader(429)
+		default:
+			w.Write([]byte(`{"applications":[{"id":1,"name":"app"}]}`))
+		}
+	}))
+	defer ts.Close()
+
+	api := NewAPI(config.Config{Account: config.Account{
+		NRApiKey:       testApiKey,
+		NRApiServer:    ts.URL,
+		NRService:      "applications",
+		NRTimeout:      testTimeout,
+		NRRetryBackoff: time.Millisecond,
+	}})
+
+	apps, err := api.GetApplications(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(apps) != 1 || requests != 3 {
+		t.Fatal("Expected 1 application after 3 requests, got", len(apps), requests)
+	}
+
+	if testutil.ToFloat64(api.retries.WithLabelValues("server_error")) != 1 || testutil.ToFloat64(api.throttled) != 1 {
+		t.Fatal("Wrong retry counters")
+	}
+
+}
+
+func TestRetryDeadline(t *testing.T) {
+
+	var requests int
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		w.Header().Set("Newrelic-Overloadprotection-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		w.WriteHeader(429)
+	}))
+	defer ts.Close()
+
+	api := NewAPI(config.Config{Account: config.Account{
+		NRApiKey:    testApiKey,
+		NRApiServer: ts.URL,
+		NRService:   "applications",
+		NRTimeout:   testTimeout,
+	}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, err := api.GetApplications(ctx)
+	if err == nil {
+		t.Fatal("Expected an error while throttled")
+	}
+
+	if requests != 1 {
+		t.Fatal("Should not wait for a reset past the deadline, made", requests, "requests")
+	}
+
+}
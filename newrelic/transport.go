@@ -0,0 +1,73 @@
+package newrelic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/mrf/newrelic_exporter/config"
+)
+
+// newHTTPClient returns the client of API requests. Requests go through
+// api.proxy-url (or debug.proxy-address), and otherwise through the proxy
+// given by HTTPS_PROXY and NO_PROXY.
+func newHTTPClient(cfg config.Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	proxy := cfg.NRProxyURL
+	if proxy == "" {
+		proxy = cfg.DebugProxyAddress
+	}
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, errors.New("could not parse proxy URL")
+		}
+		// Credentials in the URL are sent as Proxy-Authorization
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Timeout: cfg.NRTimeout, Transport: transport}, nil
+}
+
+// newTLSConfig trusts the CAs of api.tls-ca-file in addition to the system's
+// and presents the client certificate of api.tls-cert-file, if given.
+func newTLSConfig(cfg config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.NRTLSInsecure}
+
+	if cfg.NRTLSCAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.NRTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA file: %v", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.NRTLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.NRTLSCertFile != "" || cfg.NRTLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.NRTLSCertFile, cfg.NRTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
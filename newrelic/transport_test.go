@@ -0,0 +1,79 @@
+package newrelic
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/mrf/newrelic_exporter/config"
+)
+
+func TestHTTPClientTLS(t *testing.T) {
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		account config.Account
+		ok      bool
+	}{
+		{config.Account{}, false},
+		{config.Account{NRTLSCAFile: caFile}, true},
+		{config.Account{NRTLSInsecure: true}, true},
+	} {
+		client, err := newHTTPClient(config.Config{Account: test.account})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = client.Get(ts.URL)
+		if (err == nil) != test.ok {
+			t.Fatalf("Request with %+v: %v", test.account, err)
+		}
+	}
+
+	_, err = newHTTPClient(config.Config{Account: config.Account{NRTLSCAFile: filepath.Join(t.TempDir(), "missing.pem")}})
+	if err == nil {
+		t.Fatal("Expected an error for a missing CA file")
+	}
+
+}
+
+func TestHTTPClientProxy(t *testing.T) {
+
+	var proxied string
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String() + " " + r.Header.Get("Proxy-Authorization")
+		w.Write([]byte("{}"))
+	}))
+	defer proxy.Close()
+
+	client, err := newHTTPClient(config.Config{Account: config.Account{
+		NRProxyURL: "http://user:secret@" + proxy.Listener.Addr().String(),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Get("http://api.newrelic.invalid/v2/applications.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if proxied != "http://api.newrelic.invalid/v2/applications.json Basic dXNlcjpzZWNyZXQ=" {
+		t.Fatal("Request did not go through the proxy:", proxied)
+	}
+
+}
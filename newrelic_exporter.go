@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/mrf/newrelic_exporter/config"
-	"github.com/mrf/newrelic_exporter/exporter"
-	"github.com/mrf/newrelic_exporter/newrelic"
+	"github.com/mrf/newrelic_exporter/web"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/log"
@@ -16,32 +19,92 @@ func main() {
 	var configFile string
 
 	flag.StringVar(&configFile, "config", "newrelic_exporter.yml", "Config file path. Defaults to 'newrelic_exporter.yml'")
+	config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	cfg, err := config.GetConfig(configFile)
+	rl := newReloader(configFile)
 
-	api := newrelic.NewAPI(cfg)
+	cfg, err := rl.load()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	exp := exporter.NewExporter(api, cfg)
+	err = rl.apply(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rl.lastReload.Set(1)
+	rl.lastReloadTime.SetToCurrentTime()
 
-	prometheus.MustRegister(exp)
+	go rl.watch()
 
-	http.Handle(cfg.MetricPath, promhttp.Handler())
+	// The exporter's own metrics and those of every account
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, rl}
+	http.Handle(cfg.MetricPath, promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}),
+	))
+	http.Handle("/-/reload", rl)
+	http.HandleFunc("/probe", func(w http.ResponseWriter, r *http.Request) {
+		probeHandler(w, r, rl)
+	})
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 <head><title>NewRelic exporter</title></head>
 <body>
 <h1>NewRelic exporter</h1>
 <p><a href='` + cfg.MetricPath + `'>Metrics</a></p>
+<p><a href='/probe'>Probe</a> an application with <code>?app=&lt;id&gt;&amp;module=&lt;name&gt;</code></p>
 </body>
 </html>
 `))
 	})
 
 	log.Printf("Listening on %s.", cfg.ListenAddress)
-	err = http.ListenAndServe(cfg.ListenAddress, nil)
+	server := &http.Server{Addr: cfg.ListenAddress, Handler: http.DefaultServeMux}
+	err = web.ListenAndServe(server, cfg.WebConfigFile)
 	if err != nil {
 		log.Fatal(err)
 	}
 	log.Print("HTTP server stopped.")
 }
+
+// probeHandler scrapes the application given by the app parameter and serves
+// only its series. The account parameter may be omitted when a single
+// account is configured.
+func probeHandler(w http.ResponseWriter, r *http.Request, rl *reloader) {
+	params := r.URL.Query()
+
+	appID, err := strconv.Atoi(params.Get("app"))
+	if err != nil {
+		http.Error(w, "app parameter must be an application ID", http.StatusBadRequest)
+		return
+	}
+
+	exp, ok := rl.exporter(params.Get("account"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown account %q", params.Get("account")), http.StatusBadRequest)
+		return
+	}
+
+	var module config.ProbeModule
+	if name := params.Get("module"); name != "" {
+		module, ok = rl.module(name)
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown module %q", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Finish before Prometheus gives up on the scrape
+	ctx := r.Context()
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(seconds*float64(time.Second)))
+			defer cancel()
+		}
+	}
+
+	registry := exp.Probe(ctx, appID, module)
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/mrf/newrelic_exporter/config"
+	"github.com/mrf/newrelic_exporter/exporter"
+	"github.com/mrf/newrelic_exporter/newrelic"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/log"
+)
+
+// account is the exporter of one configured account and the registry it
+// is collected from.
+type account struct {
+	exporter *exporter.Exporter
+	registry *prometheus.Registry
+	stop     context.CancelFunc
+}
+
+// reloader runs the exporters of the configured accounts and applies
+// configuration changes on SIGHUP and POST /-/reload.
+type reloader struct {
+	configFile string
+
+	mu       sync.Mutex
+	cfg      config.Config
+	accounts map[string]*account
+
+	lastReload, lastReloadTime prometheus.Gauge
+}
+
+func newReloader(configFile string) *reloader {
+	rl := &reloader{
+		configFile: configFile,
+		accounts:   make(map[string]*account),
+		lastReload: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "newrelic_exporter_config_last_reload_successful",
+			Help: "Whether the last configuration reload succeeded.",
+		}),
+		lastReloadTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "newrelic_exporter_config_last_reload_success_timestamp_seconds",
+			Help: "Time of the last successful configuration reload.",
+		}),
+	}
+
+	prometheus.MustRegister(rl.lastReload, rl.lastReloadTime)
+
+	return rl
+}
+
+// load reads and validates the config file.
+func (rl *reloader) load() (config.Config, error) {
+	cfg, err := config.GetConfig(rl.configFile)
+	if err != nil {
+		return cfg, fmt.Errorf("could not load %s: %v", rl.configFile, err)
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// reload loads the config file and applies it, keeping the running
+// configuration if it is invalid.
+func (rl *reloader) reload() error {
+	cfg, err := rl.load()
+	if err == nil {
+		err = rl.apply(cfg)
+	}
+
+	if err != nil {
+		log.Errorf("Reload failed: %v", err)
+		rl.lastReload.Set(0)
+		return err
+	}
+
+	log.Info("Configuration reloaded")
+	rl.lastReload.Set(1)
+	rl.lastReloadTime.SetToCurrentTime()
+	return nil
+}
+
+// apply reloads the exporters of known accounts, stops those of removed
+// accounts and starts those of new ones. All new settings, clients and
+// registrations are prepared first, so that a failure leaves every account
+// running as before.
+func (rl *reloader) apply(cfg config.Config) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.cfg.ListenAddress != "" && (cfg.ListenAddress != rl.cfg.ListenAddress || cfg.MetricPath != rl.cfg.MetricPath || cfg.WebConfigFile != rl.cfg.WebConfigFile) {
+		log.Warn("Changes of web.listen-address, web.telemetry-path and web.config-file need a restart")
+		cfg.ListenAddress, cfg.MetricPath, cfg.WebConfigFile = rl.cfg.ListenAddress, rl.cfg.MetricPath, rl.cfg.WebConfigFile
+	}
+
+	configs := cfg.AccountConfigs()
+
+	var commits []func()
+	added := make(map[string]*account)
+
+	for _, c := range configs {
+		if a, ok := rl.accounts[c.Name]; ok {
+			commit, err := a.exporter.PrepareReload(c)
+			if err != nil {
+				return fmt.Errorf("account %q: %v", c.Name, err)
+			}
+			commits = append(commits, commit)
+			continue
+		}
+
+		a, err := newAccount(c)
+		if err != nil {
+			return fmt.Errorf("account %q: %v", c.Name, err)
+		}
+		added[c.Name] = a
+	}
+
+	for _, commit := range commits {
+		commit()
+	}
+
+	names := make(map[string]bool)
+	for _, c := range configs {
+		names[c.Name] = true
+	}
+
+	for name, a := range rl.accounts {
+		if !names[name] {
+			a.stop()
+			delete(rl.accounts, name)
+		}
+	}
+
+	for name, a := range added {
+		ctx, stop := context.WithCancel(context.Background())
+		a.stop = stop
+		rl.accounts[name] = a
+
+		go a.exporter.Run(ctx)
+	}
+
+	rl.cfg = cfg
+
+	return nil
+}
+
+// newAccount returns the exporter of an account, registered in a registry of
+// its own. Series of named accounts carry an account label.
+func newAccount(c config.Config) (*account, error) {
+	client, err := newrelic.NewClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	exp := exporter.NewExporter(client, c)
+
+	registry := prometheus.NewRegistry()
+
+	var registerer prometheus.Registerer = registry
+	if c.Name != "" {
+		registerer = prometheus.WrapRegistererWith(prometheus.Labels{"account": c.Name}, registry)
+	}
+	if err := registerer.Register(exp); err != nil {
+		return nil, err
+	}
+
+	return &account{exporter: exp, registry: registry}, nil
+}
+
+// Gather gathers the metrics of all accounts. Every account has a registry
+// of its own, which is dropped with the account.
+func (rl *reloader) Gather() ([]*dto.MetricFamily, error) {
+	rl.mu.Lock()
+	gatherers := make(prometheus.Gatherers, 0, len(rl.accounts))
+	for _, a := range rl.accounts {
+		gatherers = append(gatherers, a.registry)
+	}
+	rl.mu.Unlock()
+
+	return gatherers.Gather()
+}
+
+// watch reloads the configuration on every SIGHUP.
+func (rl *reloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		rl.reload()
+	}
+}
+
+// ServeHTTP handles POST /-/reload.
+func (rl *reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := rl.reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// exporter returns the exporter of the named account. The name may be
+// omitted when a single account is configured.
+func (rl *reloader) exporter(name string) (*exporter.Exporter, bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if a, ok := rl.accounts[name]; ok {
+		return a.exporter, true
+	}
+
+	if name == "" && len(rl.accounts) == 1 {
+		for _, a := range rl.accounts {
+			return a.exporter, true
+		}
+	}
+
+	return nil, false
+}
+
+// module returns the named probe module.
+func (rl *reloader) module(name string) (config.ProbeModule, bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	module, ok := rl.cfg.ProbeModules[name]
+	return module, ok
+}
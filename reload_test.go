@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mrf/newrelic_exporter/config"
+)
+
+func testConfig(server string, names ...string) config.Config {
+	var cfg config.Config
+
+	for _, name := range names {
+		cfg.Accounts = append(cfg.Accounts, config.Account{
+			Name:            name,
+			NRApiKey:        "key",
+			NRApiServer:     server,
+			NRService:       "applications",
+			NRPeriod:        60,
+			NRTimeout:       time.Second,
+			NRMaxRetries:    -1,
+			NRMetricFilters: []string{"Datastore"},
+		})
+	}
+
+	return cfg
+}
+
+// gathered returns the number of metrics per account label.
+func gathered(t *testing.T, rl *reloader) map[string]int {
+	families, err := rl.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts := make(map[string]int)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "account" {
+					accounts[label.GetValue()]++
+				}
+			}
+		}
+	}
+
+	return accounts
+}
+
+func TestApply(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"applications":[]}`))
+	}))
+	defer ts.Close()
+
+	rl := &reloader{accounts: make(map[string]*account)}
+	defer func() {
+		for _, a := range rl.accounts {
+			a.stop()
+		}
+	}()
+
+	if err := rl.apply(testConfig(ts.URL, "a", "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A budget shows in the metrics of a once applied, the CA file of the
+	// new account c cannot be read
+	cfg := testConfig(ts.URL, "a", "c")
+	cfg.Accounts[0].NRHourlyCallBudget = 100
+	cfg.Accounts[1].NRTLSCAFile = filepath.Join(t.TempDir(), "missing.pem")
+
+	if err := rl.apply(cfg); err == nil {
+		t.Fatal("Expected an error for the unreadable CA file")
+	}
+
+	if _, ok := rl.accounts["b"]; !ok || len(rl.accounts) != 2 {
+		t.Fatal("A failed reload should keep the accounts, got", rl.accounts)
+	}
+
+	families, err := rl.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == "newrelic_exporter_api_calls_remaining" {
+			t.Fatal("A failed reload should not apply the settings of other accounts")
+		}
+	}
+
+	// Removed accounts are no longer collected, and can be added again
+	if err := rl.apply(testConfig(ts.URL, "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if accounts := gathered(t, rl); accounts["b"] != 0 || accounts["a"] == 0 {
+		t.Fatal("Expected only the metrics of a, got", accounts)
+	}
+
+	if err := rl.apply(testConfig(ts.URL, "a", "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	if accounts := gathered(t, rl); accounts["b"] == 0 {
+		t.Fatal("Expected the metrics of b again, got", accounts)
+	}
+
+}
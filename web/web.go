@@ -0,0 +1,241 @@
+// Package web serves the exporter's endpoints with the TLS and basic
+// authentication settings of a web config file, in the format of the
+// Prometheus exporter-toolkit.
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"github.com/prometheus/log"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the content of a web config file.
+type Config struct {
+	TLSConfig TLSConfig         `yaml:"tls_server_config"`
+	Users     map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSConfig holds the certificate of the server and the verification of
+// client certificates.
+type TLSConfig struct {
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	ClientAuth string `yaml:"client_auth_type"`
+	ClientCAs  string `yaml:"client_ca_file"`
+}
+
+// Values of client_auth_type
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// GetConfig reads a web config file. Relative paths are resolved against the
+// directory of the file.
+func GetConfig(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	err = yaml.UnmarshalStrict(content, c)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(path)
+	for _, file := range []*string{&c.TLSConfig.CertFile, &c.TLSConfig.KeyFile, &c.TLSConfig.ClientCAs} {
+		if *file != "" && !filepath.IsAbs(*file) {
+			*file = filepath.Join(dir, *file)
+		}
+	}
+
+	return c, c.validate()
+}
+
+func (c *Config) validate() error {
+	t := c.TLSConfig
+
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("cert_file and key_file have to be given together")
+	}
+
+	clientAuth, ok := clientAuthTypes[t.ClientAuth]
+	if !ok {
+		return fmt.Errorf("invalid client_auth_type %q", t.ClientAuth)
+	}
+	if t.CertFile == "" && (clientAuth != tls.NoClientCert || t.ClientCAs != "") {
+		return errors.New("client certificates need cert_file and key_file")
+	}
+	if t.ClientCAs == "" && (clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert) {
+		return fmt.Errorf("client_auth_type %s needs client_ca_file", t.ClientAuth)
+	}
+
+	for user, hash := range c.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("password of %s is not a bcrypt hash: %v", user, err)
+		}
+	}
+
+	return nil
+}
+
+// tlsConfig loads the certificates of the configuration.
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.TLSConfig.CertFile, c.TLSConfig.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load server certificate: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuthTypes[c.TLSConfig.ClientAuth],
+	}
+
+	if c.TLSConfig.ClientCAs != "" {
+		pem, err := ioutil.ReadFile(c.TLSConfig.ClientCAs)
+		if err != nil {
+			return nil, fmt.Errorf("could not read client CA file: %v", err)
+		}
+
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.TLSConfig.ClientCAs)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// ListenAndServe serves server with the settings of the web config file at
+// path, or plain HTTP without a path. The file is read again on every
+// connection and request, so certificates and users can be changed without a
+// restart. Whether TLS is used is decided on startup.
+func ListenAndServe(server *http.Server, path string) error {
+	if path == "" {
+		return server.ListenAndServe()
+	}
+
+	c, err := GetConfig(path)
+	if err != nil {
+		return fmt.Errorf("invalid web config %s: %v", path, err)
+	}
+
+	server.Handler = newAuthHandler(server.Handler, path)
+
+	if c.TLSConfig.CertFile == "" {
+		if len(c.Users) > 0 {
+			log.Warn("Basic auth passwords are sent in plain text without TLS")
+		}
+		return server.ListenAndServe()
+	}
+
+	// Fail on startup rather than on the first connection
+	if _, err = c.tlsConfig(); err != nil {
+		return err
+	}
+
+	server.TLSConfig = reloadingTLSConfig(path)
+
+	return server.ListenAndServeTLS("", "")
+}
+
+// reloadingTLSConfig loads the certificates of the web config file at path
+// on every handshake.
+func reloadingTLSConfig(path string) *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c, err := GetConfig(path)
+			if err != nil {
+				log.Errorf("Invalid web config %s: %v", path, err)
+				return nil, err
+			}
+			return c.tlsConfig()
+		},
+	}
+}
+
+// authHandler requires the basic auth users of the web config file.
+type authHandler struct {
+	handler http.Handler
+	path    string
+
+	// bcrypt is slow by design, so successful logins are remembered
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]bool
+}
+
+func newAuthHandler(handler http.Handler, path string) *authHandler {
+	return &authHandler{
+		handler: handler,
+		path:    path,
+		cache:   make(map[[sha256.Size]byte]bool),
+	}
+}
+
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, err := GetConfig(h.path)
+	if err != nil {
+		log.Errorf("Invalid web config %s: %v", h.path, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if len(c.Users) > 0 {
+		user, password, ok := r.BasicAuth()
+		if !ok || !h.authenticate(c.Users[user], user, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="newrelic_exporter"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	h.handler.ServeHTTP(w, r)
+}
+
+// dummyHash is compared for unknown users, so that they take as long to
+// reject as wrong passwords and do not reveal which users exist
+const dummyHash = "$2y$10$QOauhQNbBCuQDKes6eFzPeMqBSjb7Mr5DUmpZ/VcEd00UAV/LDeSi"
+
+func (h *authHandler) authenticate(hash, user, password string) bool {
+	known := hash != ""
+	if !known {
+		hash = dummyHash
+	}
+
+	key := sha256.Sum256([]byte(hash + "\x00" + user + "\x00" + password))
+
+	h.mu.Lock()
+	ok := h.cache[key]
+	h.mu.Unlock()
+
+	if ok {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil || !known {
+		return false
+	}
+
+	h.mu.Lock()
+	h.cache[key] = true
+	h.mu.Unlock()
+
+	return true
+}
@@ -0,0 +1,184 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1 and its key.
+func writeCert(t *testing.T, dir, name string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, filepath.Join(dir, name+".pem"), string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	writeFile(t, filepath.Join(dir, name+"-key.pem"), string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeFile(t *testing.T, path, content string) {
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetConfig(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "web.yml")
+
+	for content, valid := range map[string]bool{
+		"tls_server_config:\n  cert_file: server.pem\n  key_file: server-key.pem\n":                                                                 true,
+		"tls_server_config:\n  cert_file: server.pem\n":                                                                                             false,
+		"tls_server_config:\n  cert_file: server.pem\n  key_file: server-key.pem\n  client_auth_type: RequireAndVerifyClientCert\n":                 false,
+		"tls_server_config:\n  cert_file: server.pem\n  key_file: server-key.pem\n  client_auth_type: Always\n":                                     false,
+		"tls_server_config:\n  client_auth_type: RequireAnyClientCert\n":                                                                            false,
+		"basic_auth_users:\n  prometheus: secret\n":                                                                                                 false,
+		"basic_auth_users:\n  prometheus: $2y$10$QOauhQNbBCuQDKes6eFzPeMqBSjb7Mr5DUmpZ/VcEd00UAV/LDeSi\n":                                           true,
+		"tls_server_config:\n  cert_file: server.pem\n  key_file: server-key.pem\nbasic_auth_users:\n  prometheus: secret\nunknown_setting: true\n": false,
+	} {
+		writeFile(t, path, content)
+
+		c, err := GetConfig(path)
+		if (err == nil) != valid {
+			t.Fatalf("Wrong validation of\n%s: %v", content, err)
+		}
+
+		if err == nil && c.TLSConfig.CertFile != "" && c.TLSConfig.CertFile != filepath.Join(dir, "server.pem") {
+			t.Fatal("Expected paths relative to the config file, got", c.TLSConfig.CertFile)
+		}
+	}
+
+}
+
+func TestTLSAndBasicAuth(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "web.yml")
+
+	serverCert := writeCert(t, dir, "server")
+	writeCert(t, dir, "client")
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, path, `
+tls_server_config:
+  cert_file: server.pem
+  key_file: server-key.pem
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: client.pem
+basic_auth_users:
+  prometheus: `+string(hash)+`
+`)
+
+	ts := httptest.NewUnstartedServer(newAuthHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}), path))
+	ts.TLS = reloadingTLSConfig(path)
+	ts.StartTLS()
+	defer ts.Close()
+
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert)
+
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+
+	get := func(c *http.Client, user, password string) (int, error) {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	if _, err := get(client(), "prometheus", "secret"); err == nil {
+		t.Fatal("Expected the handshake to fail without a client certificate")
+	}
+
+	for _, test := range []struct {
+		user, password string
+		status         int
+	}{
+		{"", "", 401},
+		{"prometheus", "wrong", 401},
+		{"nobody", "secret", 401},
+		{"prometheus", "secret", 200},
+		{"prometheus", "secret", 200},
+	} {
+		status, err := get(client(clientCert), test.user, test.password)
+		if err != nil || status != test.status {
+			t.Fatalf("Expected %d for %s:%s, got %d %v", test.status, test.user, test.password, status, err)
+		}
+	}
+
+	// A rotated certificate is served without a restart
+	rotated := writeCert(t, dir, "server")
+	roots.AddCert(rotated)
+
+	resp, err := client(clientCert).Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if !resp.TLS.PeerCertificates[0].Equal(rotated) {
+		t.Fatal("Expected the rotated certificate")
+	}
+
+}